@@ -0,0 +1,128 @@
+package reenvoy
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// adoptPollInterval is how often an adopted process is checked for liveness.
+const adoptPollInterval = 500 * time.Millisecond
+
+// ErrAdoptedProcess is returned when asking an adopted process to (re)start,
+// it was started by a previous supervisor and we don't know how.
+var ErrAdoptedProcess = errors.New("adopted process can not be started")
+
+// adoptedProcess is a child started by a previous supervisor. It is not our
+// child so it can not be waited on, its liveness is polled instead.
+type adoptedProcess struct {
+	pid       PID
+	command   string
+	epoch     int
+	startedAt time.Time
+
+	exitOnce sync.Once
+	exitedCh chan struct{}
+}
+
+func adoptProcess(child stateChild) *adoptedProcess {
+	p := &adoptedProcess{
+		pid:       child.PID,
+		command:   child.Command,
+		epoch:     child.Epoch,
+		startedAt: child.StartedAt,
+		exitedCh:  make(chan struct{}),
+	}
+	go p.poll()
+	return p
+}
+
+func (p *adoptedProcess) poll() {
+	for {
+		select {
+		case <-p.exitedCh:
+			return
+		case <-time.After(adoptPollInterval):
+		}
+
+		if err := syscall.Kill(int(p.pid), 0); err == syscall.ESRCH {
+			p.exitOnce.Do(func() { close(p.exitedCh) })
+			return
+		}
+	}
+}
+
+func (p *adoptedProcess) Start() error {
+	return ErrAdoptedProcess
+}
+
+func (p *adoptedProcess) Restart() error {
+	return ErrAdoptedProcess
+}
+
+// Stop send TERM to the process, it is not waited on.
+func (p *adoptedProcess) Stop() {
+	log.Println("[INFO] stopped adopted process", p.pid)
+	p.Signal(syscall.SIGTERM)
+}
+
+// Kill force kill the process.
+func (p *adoptedProcess) Kill() {
+	log.Println("[INFO] kill adopted process", p.pid)
+	p.Signal(syscall.SIGKILL)
+}
+
+// ProcessState is always nil, only the parent of a process can wait on it.
+func (p *adoptedProcess) ProcessState() *os.ProcessState {
+	return nil
+}
+
+func (p *adoptedProcess) GetPID() PID {
+	return p.pid
+}
+
+func (p *adoptedProcess) Exited() <-chan struct{} {
+	return p.exitedCh
+}
+
+// ExitStatus is always clean, the exit status of a process we did not start
+// can not be known.
+func (p *adoptedProcess) ExitStatus() (int, syscall.Signal) {
+	return ExitCodeOK, 0
+}
+
+func (p *adoptedProcess) Signal(s os.Signal) error {
+	sig, ok := s.(syscall.Signal)
+	if !ok {
+		return errors.New("unsupported signal")
+	}
+	return syscall.Kill(int(p.pid), sig)
+}
+
+// Status is Running until the process is gone, then Exited as its exit
+// status can not be known.
+func (p *adoptedProcess) Status() Status {
+	status := Status{State: StateRunning, PID: p.pid, Epoch: p.epoch, StartedAt: p.startedAt}
+	select {
+	case <-p.exitedCh:
+		status.State = StateExited
+	default:
+	}
+	return status
+}
+
+// childCommand return the command c was started with, empty when unknown.
+func childCommand(c Child) string {
+	switch c := c.(type) {
+	case *Process:
+		c.RLock()
+		defer c.RUnlock()
+		return c.command
+	case *adoptedProcess:
+		return c.command
+	}
+	return ""
+}
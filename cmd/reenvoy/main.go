@@ -0,0 +1,234 @@
+// Command reenvoy supervise envoy hot restarts, a drop-in for envoy's
+// hot-restarter.py:
+//
+//	reenvoy [flags] [start-script [args...]]
+//
+// Like hot-restarter.py the start script is run again with RESTART_EPOCH set
+// on every SIGHUP, SIGTERM and SIGINT stop every child, SIGUSR1 is forwarded to
+// every child and reenvoy exits with the status of the child that failed.
+// Without a start script envoy itself is run from the flags.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/evo3cx/reenvoy"
+)
+
+// termWaitSeconds is the TERM_WAIT_SECONDS of hot-restarter.py, the default
+// kill timeout.
+const termWaitSeconds = 30 * time.Second
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// signalValue is a signal flag, by name with or without SIG or by number.
+type signalValue struct {
+	signal *os.Signal
+}
+
+func (s signalValue) String() string {
+	if s.signal == nil || *s.signal == nil {
+		return ""
+	}
+	return (*s.signal).String()
+}
+
+func (s signalValue) Set(value string) error {
+	sig, err := parseSignal(value)
+	if err != nil {
+		return err
+	}
+	*s.signal = sig
+	return nil
+}
+
+var signals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"TERM": syscall.SIGTERM,
+}
+
+func parseSignal(value string) (os.Signal, error) {
+	if n, err := strconv.Atoi(value); err == nil && n > 0 {
+		return syscall.Signal(n), nil
+	}
+
+	name := strings.TrimPrefix(strings.ToUpper(value), "SIG")
+	if sig, ok := signals[name]; ok {
+		return sig, nil
+	}
+	return nil, fmt.Errorf("unknown signal %q", value)
+}
+
+// parseFlags map the command line onto the spawn options.
+func parseFlags(args []string, output io.Writer) (reenvoy.SpawnOptions, error) {
+	opt := reenvoy.SpawnOptions{
+		KillTimeout: termWaitSeconds,
+		Stdout:      os.Stdout,
+		StdErr:      os.Stderr,
+	}
+
+	var (
+		env, volumes, dockerEnv, extraArgs stringList
+
+		respawn, mismatch string
+	)
+
+	fs := flag.NewFlagSet("reenvoy", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.Usage = func() {
+		fmt.Fprintln(output, "usage: reenvoy [flags] [start-script [args...]]")
+		fs.PrintDefaults()
+	}
+
+	// envoy
+	fs.StringVar(&opt.ConfigPath, "config-path", "", "directory holding envoy.yaml")
+	fs.StringVar(&opt.BinaryPath, "binary", "", "envoy binary, envoy from $PATH by default")
+	fs.StringVar(&opt.Envoy.ServiceCluster, "service-cluster", "", "envoy --service-cluster")
+	fs.StringVar(&opt.Envoy.ServiceNode, "service-node", "", "envoy --service-node")
+	fs.StringVar(&opt.Envoy.ServiceZone, "service-zone", "", "envoy --service-zone")
+	fs.StringVar(&opt.Envoy.LogLevel, "log-level", "", "envoy --log-level")
+	fs.IntVar(&opt.Envoy.Concurrency, "concurrency", 0, "envoy --concurrency")
+	fs.IntVar(&opt.Envoy.BaseID, "base-id", 0, "envoy --base-id")
+	fs.Var(&extraArgs, "extra-arg", "extra envoy argument, repeatable")
+	fs.BoolVar(&opt.SkipConfigValidation, "skip-config-validation", false, "don't run envoy --mode validate before restarting")
+	fs.DurationVar(&opt.DrainTimes, "drain-time", 0, "envoy --drain-time-s (default 60s)")
+	fs.DurationVar(&opt.ParentShutdownTimes, "parent-shutdown-time", 0, "envoy --parent-shutdown-time-s (default 70s)")
+	fs.StringVar(&mismatch, "hot-restart-mismatch", "cold", "on a hot restart version change: cold, refuse or warn")
+
+	// docker
+	fs.BoolVar(&opt.DockerContainer, "docker", false, "run envoy in docker")
+	fs.StringVar(&opt.Docker.Image, "docker-image", "", "envoy image")
+	fs.StringVar(&opt.Docker.Tag, "docker-tag", "", "envoy image tag")
+	fs.StringVar(&opt.Docker.Network, "docker-network", "", "container network (default host)")
+	fs.StringVar(&opt.Docker.ConfigDir, "docker-config-dir", "", "where config-path is mounted in the container (default /testdata)")
+	fs.Var(&volumes, "docker-volume", "extra volume in the docker -v format, repeatable")
+	fs.Var(&dockerEnv, "docker-env", "environment passed to the container, NAME or NAME=value, repeatable")
+	fs.StringVar(&opt.Docker.ContainerName, "docker-container-name", "", "container name template (default reenvoy-{{.Epoch}})")
+	fs.BoolVar(&opt.Docker.Remove, "docker-rm", false, "remove the containers once exited")
+	fs.StringVar(&opt.Docker.IPC, "docker-ipc", "", "container ipc namespace (default the one of the -docker-ipc-holder container)")
+	fs.StringVar(&opt.Docker.IPCHolder, "docker-ipc-holder", "", "name of the container owning the ipc namespace every epoch joins (default reenvoy-ipc)")
+	fs.StringVar(&opt.Docker.PID, "docker-pid", "", "container pid namespace (default host, shared by every epoch)")
+	fs.StringVar(&opt.Docker.ShmSize, "docker-shm-size", "", "/dev/shm size of the ipc holder container")
+	fs.StringVar(&opt.Docker.CPUs, "docker-cpus", "", "container cpu limit")
+	fs.StringVar(&opt.Docker.Memory, "docker-memory", "", "container memory limit")
+
+	// process
+	fs.Var(&env, "env", "environment of the child as NAME=value, repeatable, ours by default")
+	fs.Var(signalValue{&opt.ReloadSignal}, "reload-signal", "signal reloading the child")
+	fs.Var(signalValue{&opt.KillSignal}, "kill-signal", "signal gracefully stopping the child")
+	fs.DurationVar(&opt.Timeout, "timeout", 0, "maximum time the child may run, unlimited by default")
+	fs.DurationVar(&opt.KillTimeout, "kill-timeout", termWaitSeconds, "time children have to exit after TERM before being killed")
+
+	// health
+	fs.StringVar(&opt.AdminAddress, "admin-address", "", "envoy admin address, a new epoch is healthy once live there")
+	fs.DurationVar(&opt.ReadyTimeout, "ready-timeout", 0, "time a new epoch has to be live on admin-address (default 30s)")
+	fs.DurationVar(&opt.HealthyTimeout, "healthy-timeout", 0, "time a new epoch has to be healthy (default 5s)")
+
+	// supervision
+	fs.StringVar(&respawn, "respawn", "never", "respawn the sole epoch when it exits: never, on-failure or always")
+	fs.DurationVar(&opt.RestartPolicy.MinInterval, "restart-min-interval", 0, "minimum time between restarts")
+	fs.DurationVar(&opt.RestartPolicy.Backoff, "restart-backoff", 0, "wait after a failed restart, doubled on every failure")
+	fs.DurationVar(&opt.RestartPolicy.MaxBackoff, "restart-max-backoff", 0, "maximum wait after failed restarts")
+	fs.IntVar(&opt.RestartPolicy.MaxFailures, "restart-max-failures", 0, "failed restarts within restart-failure-window making envoy crash-looping")
+	fs.DurationVar(&opt.RestartPolicy.FailureWindow, "restart-failure-window", 0, "window restart-max-failures are counted in")
+	fs.StringVar(&opt.StateFile, "state-file", "", "file recording the children, survivors are adopted on start")
+	fs.BoolVar(&opt.CaptureLogs, "capture-logs", false, "keep the last output of every epoch for reenvoyctl logs, envoy then writes to a pipe it loses once adopted from -state-file")
+	fs.BoolVar(&opt.WatchConfig, "watch-config", false, "restart when the files under config-path change")
+	fs.DurationVar(&opt.WatchInterval, "watch-interval", 0, "how often config-path is polled (default 1s)")
+	fs.DurationVar(&opt.WatchDebounce, "watch-debounce", 0, "how long a change must settle before restarting (default 2s)")
+
+	// endpoints
+	fs.StringVar(&opt.MetricsAddress, "metrics-address", "", "host:port serving prometheus metrics on /metrics")
+	fs.StringVar(&opt.ControlAddress, "control-address", "", "host:port or unix:/path serving the control API, a non-loopback host:port needs -control-token")
+	fs.StringVar(&opt.RunDir, "run-dir", reenvoy.DefaultRunDir(), "directory of the pid file and of the control socket reenvoyctl uses, $REENVOY_RUN_DIR by default, disabled when empty")
+	fs.StringVar(&opt.ControlToken, "control-token", os.Getenv("REENVOY_CONTROL_TOKEN"), "bearer token of the control API, $REENVOY_CONTROL_TOKEN by default")
+
+	if err := fs.Parse(args); err != nil {
+		return opt, err
+	}
+
+	if fs.NArg() > 0 {
+		opt.Command = fs.Arg(0)
+		opt.Args = fs.Args()[1:]
+	}
+
+	if len(env) > 0 {
+		opt.Env = env
+	}
+	opt.Docker.Volumes = volumes
+	opt.Docker.Env = dockerEnv
+	opt.ExtraArgs = extraArgs
+
+	switch respawn {
+	case "never":
+		opt.Respawn = reenvoy.RespawnNever
+	case "on-failure":
+		opt.Respawn = reenvoy.RespawnOnFailure
+	case "always":
+		opt.Respawn = reenvoy.RespawnAlways
+	default:
+		return opt, fmt.Errorf("invalid -respawn %q", respawn)
+	}
+
+	switch mismatch {
+	case "cold":
+		opt.HotRestartMismatch = reenvoy.HotRestartCold
+	case "refuse":
+		opt.HotRestartMismatch = reenvoy.HotRestartRefuse
+	case "warn":
+		opt.HotRestartMismatch = reenvoy.HotRestartWarn
+	default:
+		return opt, fmt.Errorf("invalid -hot-restart-mismatch %q", mismatch)
+	}
+
+	if opt.Command == "" && opt.ConfigPath == "" {
+		return opt, fmt.Errorf("either a start script or -config-path is required")
+	}
+	return opt, nil
+}
+
+func main() {
+	opt, err := parseFlags(os.Args[1:], os.Stderr)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "reenvoy:", err)
+		os.Exit(2)
+	}
+
+	if opt.Command != "" {
+		log.Println("[INFO] starting hot-restarter with target:", opt.Command)
+	}
+
+	re, err := reenvoy.Start(opt)
+	if err != nil {
+		log.Println("[ERR] start:", err)
+		os.Exit(1)
+	}
+
+	os.Exit(re.Wait())
+}
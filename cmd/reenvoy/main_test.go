@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/ioutil"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/evo3cx/reenvoy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFlags_startScript(t *testing.T) {
+	// hot-restarter.py <start-script>
+	t.Setenv("REENVOY_RUN_DIR", "")
+	opt, err := parseFlags([]string{"/etc/envoy/start.sh"}, ioutil.Discard)
+	require.Nil(t, err)
+
+	assert.Equal(t, "/etc/envoy/start.sh", opt.Command)
+	assert.Empty(t, opt.Args)
+	assert.Equal(t, termWaitSeconds, opt.KillTimeout)
+	assert.Equal(t, reenvoy.RespawnNever, opt.Respawn)
+	assert.Empty(t, opt.RunDir, "no run dir unless asked for")
+	assert.False(t, opt.CaptureLogs)
+
+	t.Setenv("REENVOY_RUN_DIR", "/run/reenvoy")
+	opt, err = parseFlags([]string{"/etc/envoy/start.sh"}, ioutil.Discard)
+	require.Nil(t, err)
+	assert.Equal(t, "/run/reenvoy", opt.RunDir)
+}
+
+func TestParseFlags(t *testing.T) {
+	opt, err := parseFlags([]string{
+		"-config-path", "/etc/envoy",
+		"-binary", "/usr/local/bin/envoy",
+		"-service-cluster", "front",
+		"-concurrency", "4",
+		"-extra-arg", "--log-format=%v",
+		"-docker",
+		"-docker-volume", "/var/log/envoy:/var/log/envoy",
+		"-docker-volume", "/certs:/certs",
+		"-kill-signal", "SIGQUIT",
+		"-reload-signal", "1",
+		"-drain-time", "30s",
+		"-respawn", "on-failure",
+		"-hot-restart-mismatch", "refuse",
+		"-restart-max-failures", "3",
+		"-control-address", "unix:/run/reenvoy.sock",
+		"-run-dir", "",
+		"-capture-logs",
+	}, ioutil.Discard)
+	require.Nil(t, err)
+
+	assert.Equal(t, "/etc/envoy", opt.ConfigPath)
+	assert.Empty(t, opt.Command)
+	assert.Equal(t, "/usr/local/bin/envoy", opt.BinaryPath)
+	assert.Equal(t, "front", opt.Envoy.ServiceCluster)
+	assert.Equal(t, 4, opt.Envoy.Concurrency)
+	assert.Equal(t, []string{"--log-format=%v"}, opt.ExtraArgs)
+	assert.True(t, opt.DockerContainer)
+	assert.Equal(t, []string{"/var/log/envoy:/var/log/envoy", "/certs:/certs"}, opt.Docker.Volumes)
+	assert.Equal(t, syscall.SIGQUIT, opt.KillSignal)
+	assert.Equal(t, syscall.SIGHUP, opt.ReloadSignal)
+	assert.Equal(t, 30*time.Second, opt.DrainTimes)
+	assert.Equal(t, reenvoy.RespawnOnFailure, opt.Respawn)
+	assert.Equal(t, reenvoy.HotRestartRefuse, opt.HotRestartMismatch)
+	assert.Equal(t, 3, opt.RestartPolicy.MaxFailures)
+	assert.True(t, opt.CaptureLogs)
+	assert.Equal(t, "unix:/run/reenvoy.sock", opt.ControlAddress)
+	assert.Empty(t, opt.RunDir)
+	assert.Nil(t, opt.Env)
+}
+
+func TestParseFlags_invalid(t *testing.T) {
+	tests := [][]string{
+		{},
+		{"-respawn", "sometimes", "start.sh"},
+		{"-hot-restart-mismatch", "ignore", "start.sh"},
+		{"-kill-signal", "SIGNOPE", "start.sh"},
+	}
+
+	for _, args := range tests {
+		_, err := parseFlags(args, ioutil.Discard)
+		assert.NotNil(t, err, "%v", args)
+	}
+}
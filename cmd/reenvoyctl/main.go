@@ -0,0 +1,452 @@
+// Command reenvoyctl talk to a running reenvoy over its control API:
+//
+//	reenvoyctl [flags] command [command flags]
+//
+// The supervisor is found through the pid file and the control socket in its
+// run dir, see reenvoy -run-dir. Every command prints a human readable summary
+// or, with -json, the response of the control API as is.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/evo3cx/reenvoy"
+)
+
+// options are the flags every command accepts.
+type options struct {
+	runDir  string
+	address string
+	token   string
+	json    bool
+	timeout time.Duration
+}
+
+// register add the common flags to fs, the current values are the defaults so
+// they may be given before or after the command.
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.runDir, "run-dir", o.runDir, "run dir of the supervisor, $REENVOY_RUN_DIR by default")
+	fs.StringVar(&o.address, "control-address", o.address, "host:port or unix:/path of the control API, instead of the run dir socket")
+	fs.StringVar(&o.token, "control-token", o.token, "bearer token of the control API, $REENVOY_CONTROL_TOKEN by default")
+	fs.BoolVar(&o.json, "json", o.json, "print the control API response as is")
+	fs.DurationVar(&o.timeout, "timeout", o.timeout, "how long to wait for the supervisor")
+}
+
+// command is a reenvoyctl command, setup register its own flags and return
+// the function sending the request once they are parsed.
+type command struct {
+	usage string
+	setup func(fs *flag.FlagSet) func(c *client, o *options, stdout io.Writer) error
+}
+
+var commands = map[string]command{
+	"status":      {"the newest epoch and the supervisor state", statusCommand},
+	"epochs":      {"every live epoch", epochsCommand},
+	"restart":     {"hot restart envoy", restartCommand},
+	"logs":        {"the last output of an epoch, the newest one by default", logsCommand},
+	"reopen-logs": {"make every epoch reopen its access logs", reopenLogsCommand},
+	"drain":       {"drain the listeners of the newest epoch", drainCommand},
+	"stop":        {"stop every epoch, the supervisor exits", stopCommand},
+}
+
+// controlError is the body of a failed control API request.
+type controlError struct {
+	Error string `json:"error"`
+}
+
+// client send requests to the control API.
+type client struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+func newClient(address, token string, timeout time.Duration) *client {
+	transport := &http.Transport{}
+	base := "http://" + address
+
+	if path := strings.TrimPrefix(address, "unix:"); path != address {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+		base = "http://reenvoy"
+	}
+
+	return &client{
+		base:  base,
+		token: token,
+		http:  &http.Client{Transport: transport, Timeout: timeout},
+	}
+}
+
+// do send the request and return the response body, an error with the
+// message of the control API when it failed.
+func (c *client) do(method, path string, query url.Values) ([]byte, error) {
+	u := c.base + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		failed := controlError{}
+		if err := json.Unmarshal(body, &failed); err != nil || failed.Error == "" {
+			return body, fmt.Errorf("%s %s returned %s", method, path, resp.Status)
+		}
+		return body, fmt.Errorf("%s", failed.Error)
+	}
+	return body, nil
+}
+
+// call send the request, print the body with -json or decode it into v.
+func (c *client) call(o *options, stdout io.Writer, method, path string, query url.Values, v interface{}) (bool, error) {
+	body, err := c.do(method, path, query)
+	if o.json && body != nil {
+		stdout.Write(body)
+		return false, err
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(body, v)
+}
+
+type child struct {
+	PID               int       `json:"pid"`
+	Epoch             int       `json:"epoch"`
+	State             string    `json:"state"`
+	StartedAt         time.Time `json:"started_at"`
+	HotRestartVersion string    `json:"hot_restart_version"`
+}
+
+type status struct {
+	State        string    `json:"state"`
+	PID          int       `json:"pid"`
+	Epoch        int       `json:"epoch"`
+	StartedAt    time.Time `json:"started_at"`
+	ExitCode     int       `json:"exit_code"`
+	Signal       int       `json:"signal"`
+	Error        string    `json:"error"`
+	CrashLooping bool      `json:"crash_looping"`
+	Children     []child   `json:"children"`
+}
+
+// since print when t was, how long ago.
+func since(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return fmt.Sprintf("%s (%s ago)", t.Format(time.RFC3339), time.Since(t).Round(time.Second))
+}
+
+func statusCommand(fs *flag.FlagSet) func(c *client, o *options, stdout io.Writer) error {
+	return func(c *client, o *options, stdout io.Writer) error {
+		s := status{}
+		if ok, err := c.call(o, stdout, http.MethodGet, "/status", nil, &s); !ok {
+			return err
+		}
+
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "state:\t%s\n", s.State)
+		fmt.Fprintf(w, "epoch:\t%d\n", s.Epoch)
+		fmt.Fprintf(w, "pid:\t%d\n", s.PID)
+		fmt.Fprintf(w, "started:\t%s\n", since(s.StartedAt))
+		fmt.Fprintf(w, "children:\t%d\n", len(s.Children))
+		fmt.Fprintf(w, "crash-looping:\t%t\n", s.CrashLooping)
+		if s.State == "exited" || s.State == "failed" {
+			fmt.Fprintf(w, "exit code:\t%d\n", s.ExitCode)
+			if s.Signal != 0 {
+				fmt.Fprintf(w, "signal:\t%d\n", s.Signal)
+			}
+		}
+		if s.Error != "" {
+			fmt.Fprintf(w, "error:\t%s\n", s.Error)
+		}
+		return w.Flush()
+	}
+}
+
+func epochsCommand(fs *flag.FlagSet) func(c *client, o *options, stdout io.Writer) error {
+	return func(c *client, o *options, stdout io.Writer) error {
+		s := status{}
+		if ok, err := c.call(o, stdout, http.MethodGet, "/status", nil, &s); !ok {
+			return err
+		}
+
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "EPOCH\tPID\tSTATE\tSTARTED\tHOT RESTART VERSION")
+		for _, ch := range s.Children {
+			version := ch.HotRestartVersion
+			if version == "" {
+				version = "-"
+			}
+			fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\n", ch.Epoch, ch.PID, ch.State, since(ch.StartedAt), version)
+		}
+		return w.Flush()
+	}
+}
+
+func restartCommand(fs *flag.FlagSet) func(c *client, o *options, stdout io.Writer) error {
+	drain := fs.Duration("drain-time", 0, "envoy --drain-time-s of this restart only")
+	parentShutdown := fs.Duration("parent-shutdown-time", 0, "envoy --parent-shutdown-time-s of this restart only")
+
+	return func(c *client, o *options, stdout io.Writer) error {
+		query := url.Values{}
+		if *drain > 0 {
+			query.Set("drain_time_s", strconv.Itoa(int(drain.Seconds())))
+		}
+		if *parentShutdown > 0 {
+			query.Set("parent_shutdown_time_s", strconv.Itoa(int(parentShutdown.Seconds())))
+		}
+
+		res := struct {
+			Epoch      int    `json:"epoch"`
+			PID        int    `json:"pid"`
+			RolledBack bool   `json:"rolled_back"`
+			Reason     string `json:"reason"`
+		}{}
+		body, err := c.do(http.MethodPost, "/restart", query)
+		if o.json && body != nil {
+			stdout.Write(body)
+			return err
+		}
+		if err != nil {
+			// a rolled back restart tells why along with the error
+			if json.Unmarshal(body, &res) == nil && res.RolledBack {
+				return fmt.Errorf("%s, rolled back: %s", err, res.Reason)
+			}
+			return err
+		}
+		if err := json.Unmarshal(body, &res); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(stdout, "restarted, epoch %d PID=%d\n", res.Epoch, res.PID)
+		return nil
+	}
+}
+
+func logsCommand(fs *flag.FlagSet) func(c *client, o *options, stdout io.Writer) error {
+	epoch := fs.Int("epoch", -1, "epoch to show the output of, the newest one by default")
+
+	return func(c *client, o *options, stdout io.Writer) error {
+		query := url.Values{}
+		if *epoch >= 0 {
+			query.Set("epoch", strconv.Itoa(*epoch))
+		}
+
+		logs := struct {
+			Logs string `json:"logs"`
+		}{}
+		if ok, err := c.call(o, stdout, http.MethodGet, "/logs", query, &logs); !ok {
+			return err
+		}
+		_, err := io.WriteString(stdout, logs.Logs)
+		return err
+	}
+}
+
+func reopenLogsCommand(fs *flag.FlagSet) func(c *client, o *options, stdout io.Writer) error {
+	return func(c *client, o *options, stdout io.Writer) error {
+		if ok, err := c.call(o, stdout, http.MethodPost, "/reopen-logs", nil, &struct{}{}); !ok {
+			return err
+		}
+		fmt.Fprintln(stdout, "logs reopened")
+		return nil
+	}
+}
+
+func drainCommand(fs *flag.FlagSet) func(c *client, o *options, stdout io.Writer) error {
+	inboundOnly := fs.Bool("inbound-only", false, "only drain the inbound listeners")
+
+	return func(c *client, o *options, stdout io.Writer) error {
+		query := url.Values{}
+		if *inboundOnly {
+			query.Set("inbound_only", "true")
+		}
+
+		drained := struct {
+			Epoch int `json:"epoch"`
+		}{}
+		if ok, err := c.call(o, stdout, http.MethodPost, "/drain", query, &drained); !ok {
+			return err
+		}
+		fmt.Fprintf(stdout, "draining the listeners of epoch %d\n", drained.Epoch)
+		return nil
+	}
+}
+
+// pids print a list of pids, - when empty.
+func pids(list []int) string {
+	if len(list) == 0 {
+		return "-"
+	}
+	strs := make([]string, 0, len(list))
+	for _, pid := range list {
+		strs = append(strs, strconv.Itoa(pid))
+	}
+	return strings.Join(strs, " ")
+}
+
+func stopCommand(fs *flag.FlagSet) func(c *client, o *options, stdout io.Writer) error {
+	return func(c *client, o *options, stdout io.Writer) error {
+		stopped := struct {
+			Exited    []int          `json:"exited"`
+			ExitCodes map[int]int    `json:"exit_codes"`
+			Killed    []int          `json:"killed"`
+			Errors    map[int]string `json:"errors"`
+		}{}
+		if ok, err := c.call(o, stdout, http.MethodPost, "/stop", nil, &stopped); !ok {
+			return err
+		}
+
+		exited := make([]string, 0, len(stopped.Exited))
+		for _, pid := range stopped.Exited {
+			if code := stopped.ExitCodes[pid]; code != 0 {
+				exited = append(exited, fmt.Sprintf("%d (status %d)", pid, code))
+				continue
+			}
+			exited = append(exited, strconv.Itoa(pid))
+		}
+		if len(exited) == 0 {
+			exited = append(exited, "-")
+		}
+
+		fmt.Fprintf(stdout, "exited: %s\n", strings.Join(exited, " "))
+		fmt.Fprintf(stdout, "killed: %s\n", pids(stopped.Killed))
+		failed := make([]int, 0, len(stopped.Errors))
+		for pid := range stopped.Errors {
+			failed = append(failed, pid)
+		}
+		sort.Ints(failed)
+		for _, pid := range failed {
+			fmt.Fprintf(stdout, "PID=%d: %s\n", pid, stopped.Errors[pid])
+		}
+		return nil
+	}
+}
+
+func usage(fs *flag.FlagSet, output io.Writer) func() {
+	return func() {
+		fmt.Fprintln(output, "usage: reenvoyctl [flags] command [command flags]")
+		fmt.Fprintln(output, "\ncommands:")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		w := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+		for _, name := range names {
+			fmt.Fprintf(w, "  %s\t%s\n", name, commands[name].usage)
+		}
+		w.Flush()
+
+		fmt.Fprintln(output, "\nflags:")
+		fs.PrintDefaults()
+	}
+}
+
+// run execute the command line and return the exit status, 2 for a usage
+// error and 1 when the supervisor could not be reached or refused.
+func run(args []string, stdout, stderr io.Writer) int {
+	o := &options{
+		runDir:  reenvoy.DefaultRunDir(),
+		token:   os.Getenv("REENVOY_CONTROL_TOKEN"),
+		timeout: 2 * time.Minute,
+	}
+
+	fs := flag.NewFlagSet("reenvoyctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = usage(fs, stderr)
+	o.register(fs)
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	name := fs.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "reenvoyctl: unknown command %q\n", name)
+		fs.Usage()
+		return 2
+	}
+
+	cmdFlags := flag.NewFlagSet("reenvoyctl "+name, flag.ContinueOnError)
+	cmdFlags.SetOutput(stderr)
+	o.register(cmdFlags)
+	send := cmd.setup(cmdFlags)
+	if err := cmdFlags.Parse(fs.Args()[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if cmdFlags.NArg() > 0 {
+		fmt.Fprintf(stderr, "reenvoyctl: %s takes no arguments\n", name)
+		return 2
+	}
+
+	address := o.address
+	if address == "" && o.runDir == "" {
+		fmt.Fprintln(stderr, "reenvoyctl: no supervisor to talk to, give -run-dir, $REENVOY_RUN_DIR or -control-address")
+		return 2
+	}
+	if address == "" {
+		if _, err := reenvoy.ReadPIDFile(o.runDir); err != nil {
+			fmt.Fprintln(stderr, "reenvoyctl:", err)
+			return 1
+		}
+		address = reenvoy.ControlSocket(o.runDir)
+	}
+
+	if err := send(newClient(address, o.token, o.timeout), o, stdout); err != nil {
+		fmt.Fprintf(stderr, "reenvoyctl: %s: %s\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
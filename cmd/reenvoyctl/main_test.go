@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/evo3cx/reenvoy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const statusBody = `{"state":"running","pid":102,"epoch":1,"started_at":"2026-10-17T10:00:00Z","exit_code":0,"crash_looping":false,"children":[
+	{"pid":101,"epoch":0,"state":"draining","started_at":"2026-10-17T09:00:00Z","hot_restart_version":"11.104"},
+	{"pid":102,"epoch":1,"state":"running","started_at":"2026-10-17T10:00:00Z"}]}
+`
+
+// fakeSupervisor serve a canned control API in a run dir, requests are
+// recorded as "METHOD /path?query".
+func fakeSupervisor(t *testing.T) (runDir string, requests *[]string, stop func()) {
+	dir, err := ioutil.TempDir("", "reenvoyctl")
+	require.Nil(t, err)
+
+	pidFile := filepath.Join(dir, reenvoy.PIDFileName)
+	require.Nil(t, ioutil.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644))
+
+	ln, err := net.Listen("unix", filepath.Join(dir, reenvoy.ControlSocketName))
+	require.Nil(t, err)
+
+	requests = &[]string{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		*requests = append(*requests, req.Method+" "+req.URL.RequestURI())
+		if req.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintln(w, `{"error":"invalid bearer token"}`)
+			return
+		}
+
+		switch req.URL.Path {
+		case "/status":
+			fmt.Fprint(w, statusBody)
+		case "/restart":
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, `{"epoch":2,"pid":0,"rolled_back":true,"reason":"epoch 2 not healthy","error":"restart failed"}`)
+		case "/logs":
+			fmt.Fprintln(w, `{"epoch":0,"logs":"starting envoy\n"}`)
+		case "/stop":
+			fmt.Fprintln(w, `{"exited":[100,101],"exit_codes":{"100":0,"101":3},"killed":[102],"errors":{"102":"killed"}}`)
+		default:
+			fmt.Fprintln(w, `{"epoch":1}`)
+		}
+	})
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+
+	return dir, requests, func() {
+		srv.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRun(t *testing.T) {
+	runDir, requests, stop := fakeSupervisor(t)
+	defer stop()
+
+	tests := []struct {
+		args    []string
+		code    int
+		request string
+		output  string
+	}{
+		{
+			args:    []string{"status"},
+			request: "GET /status",
+			output:  "state:          running\nepoch:          1\npid:            102\n",
+		},
+		{
+			args:    []string{"epochs"},
+			request: "GET /status",
+			output:  "EPOCH  PID  STATE     STARTED",
+		},
+		{
+			args:    []string{"-json", "status"},
+			request: "GET /status",
+			output:  statusBody,
+		},
+		{
+			args:    []string{"logs", "-epoch", "0"},
+			request: "GET /logs?epoch=0",
+			output:  "starting envoy\n",
+		},
+		{
+			args:    []string{"restart", "-drain-time", "30s"},
+			code:    1,
+			request: "POST /restart?drain_time_s=30",
+		},
+		{
+			args:    []string{"drain", "--inbound-only"},
+			request: "POST /drain?inbound_only=true",
+			output:  "draining the listeners of epoch 1\n",
+		},
+		{
+			args:    []string{"reopen-logs"},
+			request: "POST /reopen-logs",
+			output:  "logs reopened\n",
+		},
+		{
+			args:    []string{"stop"},
+			request: "POST /stop",
+			output:  "exited: 100 101 (status 3)\nkilled: 102\nPID=102: killed\n",
+		},
+		{
+			args:    []string{"status", "-control-token", "wrong"},
+			code:    1,
+			request: "GET /status",
+		},
+	}
+
+	for _, tt := range tests {
+		*requests = nil
+		var stdout bytes.Buffer
+		args := append([]string{"-run-dir", runDir, "-control-token", "secret"}, tt.args...)
+
+		code := run(args, &stdout, ioutil.Discard)
+		assert.Equal(t, tt.code, code, "%v", tt.args)
+		assert.Equal(t, []string{tt.request}, *requests, "%v", tt.args)
+		assert.Contains(t, stdout.String(), tt.output, "%v", tt.args)
+	}
+}
+
+func TestRun_restartRolledBack(t *testing.T) {
+	runDir, _, stop := fakeSupervisor(t)
+	defer stop()
+
+	var stderr bytes.Buffer
+	code := run([]string{"-run-dir", runDir, "-control-token", "secret", "restart"}, ioutil.Discard, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "reenvoyctl: restart: restart failed, rolled back: epoch 2 not healthy\n", stderr.String())
+}
+
+func TestRun_invalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reenvoyctl")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.Equal(t, 2, run([]string{}, ioutil.Discard, ioutil.Discard))
+	assert.Equal(t, 2, run([]string{"reload"}, ioutil.Discard, ioutil.Discard))
+	assert.Equal(t, 2, run([]string{"logs", "-epoch", "x"}, ioutil.Discard, ioutil.Discard))
+	assert.Equal(t, 2, run([]string{"status", "now"}, ioutil.Discard, ioutil.Discard))
+
+	var stderr bytes.Buffer
+	assert.Equal(t, 1, run([]string{"-run-dir", dir, "status"}, ioutil.Discard, &stderr))
+	assert.Contains(t, stderr.String(), "reenvoy is not running")
+
+	// reenvoy has no run dir by default either
+	t.Setenv("REENVOY_RUN_DIR", "")
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"status"}, ioutil.Discard, &stderr))
+	assert.Contains(t, stderr.String(), "give -run-dir, $REENVOY_RUN_DIR or -control-address")
+
+	t.Setenv("REENVOY_RUN_DIR", dir)
+	stderr.Reset()
+	assert.Equal(t, 1, run([]string{"status"}, ioutil.Discard, &stderr))
+	assert.Contains(t, stderr.String(), "reenvoy is not running")
+}
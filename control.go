@@ -0,0 +1,354 @@
+package reenvoy
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// controlShutdownTimeout is how long the control server waits for running
+// requests, a /stop is answered before the server goes away.
+const controlShutdownTimeout = 5 * time.Second
+
+// controlChild is a child as listed by GET /status.
+type controlChild struct {
+	PID               PID       `json:"pid"`
+	Epoch             int       `json:"epoch"`
+	State             string    `json:"state"`
+	StartedAt         time.Time `json:"started_at"`
+	HotRestartVersion string    `json:"hot_restart_version,omitempty"`
+}
+
+// controlStatus is the GET /status response.
+type controlStatus struct {
+	State        string         `json:"state"`
+	PID          PID            `json:"pid"`
+	Epoch        int            `json:"epoch"`
+	StartedAt    time.Time      `json:"started_at"`
+	ExitCode     int            `json:"exit_code"`
+	Signal       int            `json:"signal,omitempty"`
+	Error        string         `json:"error,omitempty"`
+	CrashLooping bool           `json:"crash_looping"`
+	Children     []controlChild `json:"children"`
+}
+
+// controlRestart is the POST /restart response.
+type controlRestart struct {
+	Epoch      int    `json:"epoch"`
+	PID        PID    `json:"pid"`
+	RolledBack bool   `json:"rolled_back"`
+	Reason     string `json:"reason,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// controlLogs is the GET /logs response.
+type controlLogs struct {
+	Epoch int    `json:"epoch"`
+	Logs  string `json:"logs"`
+}
+
+// controlStop is the POST /stop response.
+type controlStop struct {
+	Exited    []PID          `json:"exited"`
+	ExitCodes map[PID]int    `json:"exit_codes"`
+	Killed    []PID          `json:"killed"`
+	Errors    map[PID]string `json:"errors,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("[WARN] control response:", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// controlRoute only let method through and check the bearer token, if any.
+func (r *Reenvoy) controlRoute(method string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if token := r.options().ControlToken; token != "" {
+			auth := req.Header.Get("Authorization")
+			given := strings.TrimPrefix(auth, "Bearer ")
+			if given == auth || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid bearer token"))
+				return
+			}
+		}
+
+		if req.Method != method {
+			w.Header().Set("Allow", method)
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s only", method))
+			return
+		}
+		h(w, req)
+	}
+}
+
+// ControlHandler serve the control API:
+//
+//	POST /restart      restart envoy, drain_time_s and parent_shutdown_time_s
+//	                   override the drain times for this restart only
+//	POST /reopen-logs  send SIGUSR1 to every child
+//	POST /drain        drain the listeners of the newest epoch, only the
+//	                   inbound ones with inbound_only=true
+//	POST /stop         stop every child
+//	GET  /status       the newest epoch and every live child
+//	GET  /logs         the last output of epoch, the newest one by default
+//	GET  /healthz      200 while envoy is running
+func (r *Reenvoy) ControlHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/restart", r.controlRoute(http.MethodPost, r.handleRestart))
+	mux.HandleFunc("/reopen-logs", r.controlRoute(http.MethodPost, r.handleReopenLogs))
+	mux.HandleFunc("/drain", r.controlRoute(http.MethodPost, r.handleDrain))
+	mux.HandleFunc("/stop", r.controlRoute(http.MethodPost, r.handleStop))
+	mux.HandleFunc("/status", r.controlRoute(http.MethodGet, r.handleStatus))
+	mux.HandleFunc("/logs", r.controlRoute(http.MethodGet, r.handleLogs))
+	mux.HandleFunc("/healthz", r.controlRoute(http.MethodGet, r.handleHealthz))
+	return mux
+}
+
+// durationParam read a duration in seconds from the query or form, zero when
+// not given.
+func durationParam(req *http.Request, name string) (time.Duration, error) {
+	value := req.FormValue(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("%s must be a number of seconds", name)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func (r *Reenvoy) handleRestart(w http.ResponseWriter, req *http.Request) {
+	drain, err := durationParam(req, "drain_time_s")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	parentShutdown, err := durationParam(req, "parent_shutdown_time_s")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	res := r.restartDrain(drain, parentShutdown)
+	body := controlRestart{Epoch: res.Epoch, PID: res.PID, RolledBack: res.RolledBack, Reason: res.Reason}
+
+	code := http.StatusOK
+	if res.Err != nil {
+		body.Error = res.Err.Error()
+		code = http.StatusInternalServerError
+		switch res.Err.(type) {
+		case *ConfigValidationError:
+			code = http.StatusUnprocessableEntity
+		case *HotRestartVersionError:
+			code = http.StatusConflict
+		}
+		if res.Err == ErrRestartThrottled {
+			code = http.StatusTooManyRequests
+		}
+	}
+	writeJSON(w, code, body)
+}
+
+// restartDrain restart with other drain and parent shutdown times, zero keeps
+// ours. They only apply to this restart.
+func (r *Reenvoy) restartDrain(drain, parentShutdown time.Duration) RestartResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current := r.options()
+	opt := current
+	if drain > 0 {
+		opt.DrainTimes = drain
+	}
+	if parentShutdown > 0 {
+		opt.ParentShutdownTimes = parentShutdown
+	}
+
+	// the drain times only apply to this restart
+	res := r.restartWith(opt)
+	opt = r.options()
+	opt.DrainTimes, opt.ParentShutdownTimes = current.DrainTimes, current.ParentShutdownTimes
+	r.setOptions(opt)
+	return res
+}
+
+func (r *Reenvoy) handleReopenLogs(w http.ResponseWriter, req *http.Request) {
+	if err := r.ReopenLogs(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{})
+}
+
+func (r *Reenvoy) handleDrain(w http.ResponseWriter, req *http.Request) {
+	inboundOnly := false
+	if value := req.FormValue("inbound_only"); value != "" {
+		var err error
+		if inboundOnly, err = strconv.ParseBool(value); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("inbound_only must be a boolean"))
+			return
+		}
+	}
+
+	if err := r.DrainListeners(inboundOnly); err != nil {
+		code := http.StatusBadGateway
+		if err == ErrNoAdminAddress {
+			code = http.StatusConflict
+		}
+		writeError(w, code, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"epoch": r.Epoch()})
+}
+
+func (r *Reenvoy) handleLogs(w http.ResponseWriter, req *http.Request) {
+	epoch := r.Epoch()
+	if value := req.FormValue("epoch"); value != "" {
+		var err error
+		if epoch, err = strconv.Atoi(value); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("epoch must be a number"))
+			return
+		}
+	}
+
+	logs, err := r.Logs(epoch)
+	if err != nil {
+		code := http.StatusNotFound
+		if err == ErrLogsNotCaptured {
+			code = http.StatusConflict
+		}
+		writeError(w, code, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, controlLogs{Epoch: epoch, Logs: string(logs)})
+}
+
+func (r *Reenvoy) handleStop(w http.ResponseWriter, req *http.Request) {
+	// not the request context, a client going away must not kill envoy
+	res := r.StopAllChildren(context.Background())
+
+	body := controlStop{Exited: res.Exited, ExitCodes: res.ExitCodes, Killed: res.Killed, Errors: map[PID]string{}}
+	for pid, err := range res.Errors {
+		body.Errors[pid] = err.Error()
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (r *Reenvoy) handleStatus(w http.ResponseWriter, req *http.Request) {
+	status := r.Status()
+	body := controlStatus{
+		State:        status.State.String(),
+		PID:          status.PID,
+		Epoch:        status.Epoch,
+		StartedAt:    status.StartedAt,
+		ExitCode:     status.ExitCode,
+		Signal:       int(status.Signal),
+		CrashLooping: r.CrashLooping(),
+		Children:     []controlChild{},
+	}
+	if status.Err != nil {
+		body.Error = status.Err.Error()
+	}
+
+	for _, epoch := range r.Children() {
+		body.Children = append(body.Children, controlChild{
+			PID:               epoch.PID,
+			Epoch:             epoch.Epoch,
+			State:             epoch.State.String(),
+			StartedAt:         epoch.StartedAt,
+			HotRestartVersion: epoch.HotRestartVersion,
+		})
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (r *Reenvoy) handleHealthz(w http.ResponseWriter, req *http.Request) {
+	switch state := r.Status().State; state {
+	case StateRunning, StateDraining:
+		writeJSON(w, http.StatusOK, map[string]string{"state": state.String()})
+	default:
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"state": state.String()})
+	}
+}
+
+// loopbackAddress tells whether the host:port address is only reachable from
+// this host.
+func loopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// controlListen listen on address, a unix socket when it starts with unix:.
+// A stale socket is removed and the new one is only reachable by our user. A
+// TCP address reachable from the network needs a token, anyone could stop
+// envoy otherwise.
+func controlListen(address, token string) (net.Listener, error) {
+	path := strings.TrimPrefix(address, "unix:")
+	if path == address {
+		if token == "" && !loopbackAddress(address) {
+			return nil, fmt.Errorf("%s is not a loopback address, set a control token to serve on it", address)
+		}
+		return net.Listen("tcp", address)
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// serveControl serve ControlHandler at Options.ControlAddress until every
+// child is gone.
+func (r *Reenvoy) serveControl() error {
+	opt := r.options()
+	ln, err := controlListen(opt.ControlAddress, opt.ControlToken)
+	if err != nil {
+		return fmt.Errorf("control listener: %s", err)
+	}
+
+	srv := &http.Server{Handler: r.ControlHandler()}
+	r.listeners = append(r.listeners, ln)
+
+	log.Printf("[INFO] serving control API on %s\n", opt.ControlAddress)
+	go srv.Serve(ln)
+	go func() {
+		<-r.done
+		ctx, cancel := context.WithTimeout(context.Background(), controlShutdownTimeout)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+	return nil
+}
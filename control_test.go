@@ -0,0 +1,151 @@
+package reenvoy_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/evo3cx/reenvoy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unixClient return a client talking to the unix socket at path.
+func unixClient(path string) *http.Client {
+	return &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
+
+func TestReenvoy_Control(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reenvoy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "control.sock")
+	opts := loopOptions()
+	opts.Args = []string{"-c", "trap 'exit 0' TERM; trap ':' USR1; while true; do sleep 0.1; done"}
+	opts.ControlAddress = "unix:" + socket
+	opts.ControlToken = "secret"
+
+	re, err := reenvoy.Start(opts)
+	require.Nil(t, err, "start reenvoy")
+
+	info, err := os.Stat(socket)
+	require.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	client := unixClient(socket)
+	do := func(method, path, token string) *http.Response {
+		req, err := http.NewRequest(method, "http://reenvoy"+path, nil)
+		require.Nil(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		require.Nil(t, err)
+		return resp
+	}
+
+	resp := do("GET", "/status", "")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// the token goes with the Bearer scheme only
+	req, err := http.NewRequest("GET", "http://reenvoy/status", nil)
+	require.Nil(t, err)
+	req.Header.Set("Authorization", "secret")
+	resp, err = client.Do(req)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = do("GET", "/restart", "secret")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	resp = do("POST", "/restart?drain_time_s=5&parent_shutdown_time_s=10", "secret")
+	var restart struct {
+		Epoch int    `json:"epoch"`
+		Error string `json:"error"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&restart))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 1, restart.Epoch)
+	assert.Empty(t, restart.Error)
+
+	resp = do("GET", "/status", "secret")
+	var status struct {
+		State    string `json:"state"`
+		Epoch    int    `json:"epoch"`
+		Children []struct {
+			Epoch int    `json:"epoch"`
+			State string `json:"state"`
+		} `json:"children"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&status))
+	resp.Body.Close()
+	assert.Equal(t, "running", status.State)
+	assert.Equal(t, 1, status.Epoch)
+	require.Len(t, status.Children, 2)
+	assert.Equal(t, "draining", status.Children[0].State)
+
+	resp = do("GET", "/healthz", "secret")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do("POST", "/reopen-logs", "secret")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do("POST", "/stop", "secret")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 0, re.Wait())
+}
+
+func TestReenvoy_ControlAddressLoopback(t *testing.T) {
+	tests := []struct {
+		address string
+		token   string
+		wantErr bool
+	}{
+		{address: "127.0.0.1:0"},
+		{address: "[::1]:0"},
+		{address: "localhost:0"},
+		{address: "0.0.0.0:0", wantErr: true},
+		{address: ":0", wantErr: true},
+		{address: "0.0.0.0:0", token: "secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address+tt.token, func(t *testing.T) {
+			opts := loopOptions()
+			opts.ControlAddress = tt.address
+			opts.ControlToken = tt.token
+
+			re, err := reenvoy.Start(opts)
+			if tt.wantErr {
+				require.NotNil(t, err)
+				assert.Contains(t, err.Error(), "not a loopback address")
+				return
+			}
+			require.Nil(t, err, "start reenvoy")
+			re.StopAllChildren(context.Background())
+			re.Wait()
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package reenvoy
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"text/template"
+)
+
+const (
+	defaultDockerImage   = "envoyproxy/envoy"
+	defaultDockerTag     = "3f59fb5c0f6554f8b3f2e73ab4c1437a63d42668"
+	defaultDockerNetwork = "host"
+	defaultDockerConfig  = "/testdata"
+
+	// defaultContainerName is used when no ContainerName is given, every
+	// epoch runs in a container of its own.
+	defaultContainerName = "reenvoy-{{.Epoch}}"
+	// defaultIPCHolder is the container owning the ipc namespace of every
+	// epoch, when no IPCHolder is given.
+	defaultIPCHolder = "reenvoy-ipc"
+)
+
+// DockerOptions configure the container envoy runs in when DockerContainer is
+// set. Zero values are left out of the docker command line.
+type DockerOptions struct {
+	// Image and Tag of the envoy image, envoyproxy/envoy at a pinned commit by
+	// default.
+	Image string
+	Tag   string
+
+	// Network is the network the container joins, host by default.
+	Network string
+
+	// ConfigDir is where ConfigPath is mounted in the container, /testdata by
+	// default.
+	ConfigDir string
+
+	// Volumes are extra volume mounts in the docker -v format,
+	// e.g. /var/log/envoy:/var/log/envoy.
+	Volumes []string
+
+	// Env are passed through to the container, either NAME to pass our own
+	// value or NAME=value. RESTART_EPOCH is always passed through.
+	Env []string
+
+	// ContainerName is the template of the container name, executed with the
+	// restart epoch, reenvoy-{{.Epoch}} by default.
+	ContainerName string
+
+	// Remove the container once it exited.
+	Remove bool
+
+	// IPC and PID are the ipc and pid namespaces the container uses, e.g. host
+	// or container:<name>. Hot restart needs epochs to share them, when empty
+	// every epoch joins the ipc namespace of the IPCHolder container and the
+	// host pid namespace, see shareNamespaces.
+	IPC string
+	PID string
+
+	// IPCHolder is the name of the container owning the ipc namespace when IPC
+	// is empty, reenvoy-ipc by default. It runs sleep from Image, lives as long
+	// as any epoch and is removed with the last one.
+	IPCHolder string
+
+	// ShmSize is the size of /dev/shm, in the docker --shm-size format. It is
+	// set on the IPCHolder, the epochs use its /dev/shm.
+	ShmSize string
+
+	// CPUs and Memory limit the container resources, in the docker --cpus
+	// and --memory format.
+	CPUs   string
+	Memory string
+}
+
+// Validate check the container name template.
+func (d DockerOptions) Validate() error {
+	if _, err := d.containerName(0); err != nil {
+		return fmt.Errorf("invalid docker container name: %s", err)
+	}
+	return nil
+}
+
+// image return the image reference to run.
+func (d DockerOptions) image() string {
+	image, tag := d.Image, d.Tag
+	if image == "" {
+		image = defaultDockerImage
+		if tag == "" {
+			tag = defaultDockerTag
+		}
+	}
+
+	if tag == "" {
+		return image
+	}
+	return image + ":" + tag
+}
+
+func (d DockerOptions) configDir() string {
+	if d.ConfigDir == "" {
+		return defaultDockerConfig
+	}
+	return d.ConfigDir
+}
+
+// containerName execute the ContainerName template for the restart epoch.
+func (d DockerOptions) containerName(restartEpoch int) (string, error) {
+	text := d.ContainerName
+	if text == "" {
+		text = defaultContainerName
+	}
+
+	tmpl, err := template.New("name").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var name bytes.Buffer
+	data := struct{ Epoch int }{Epoch: restartEpoch}
+	if err := tmpl.Execute(&name, data); err != nil {
+		return "", err
+	}
+	return name.String(), nil
+}
+
+// runArgs render the docker run options, up to and including the image.
+func (d DockerOptions) runArgs(configPath string, restartEpoch int) ([]string, error) {
+	args := []string{"run"}
+	if d.Remove {
+		args = append(args, "--rm")
+	}
+
+	name, err := d.containerName(restartEpoch)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, "--name", name)
+
+	network := d.Network
+	if network == "" {
+		network = defaultDockerNetwork
+	}
+	args = append(args, "--network", network)
+
+	if d.IPC != "" {
+		args = append(args, "--ipc", d.IPC)
+	}
+	if d.PID != "" {
+		args = append(args, "--pid", d.PID)
+	}
+	if d.ShmSize != "" {
+		args = append(args, "--shm-size", d.ShmSize)
+	}
+	if d.CPUs != "" {
+		args = append(args, "--cpus", d.CPUs)
+	}
+	if d.Memory != "" {
+		args = append(args, "--memory", d.Memory)
+	}
+
+	args = append(args, "-v", fmt.Sprintf("%s:%s", configPath, d.configDir()))
+	for _, volume := range d.Volumes {
+		args = append(args, "-v", volume)
+	}
+
+	args = append(args, "-e", "RESTART_EPOCH")
+	for _, env := range d.Env {
+		args = append(args, "-e", env)
+	}
+
+	return append(args, d.image()), nil
+}
+
+// shareNamespaces return d with the namespaces every epoch shares to hot
+// restart. Envoy keeps its hot restart state in /dev/shm, so without a shared
+// ipc namespace a new container can not see the previous epoch and the
+// restart is a cold one. Joining the parent container does not do: docker
+// gives the joining container the /dev/shm mount of the donor, which goes
+// away with the donor, so every epoch would end up with the /dev/shm of a
+// long gone first epoch. Every epoch joins the IPCHolder instead, a container
+// doing nothing but outliving them.
+//
+// A pid namespace does not outlive its first process: envoy is PID 1 of the
+// container, once a parent epoch exits at the end of its drain the kernel
+// kills every process left in its namespace, the new epoch included. So every
+// epoch shares the host pid namespace. Namespaces set explicitly are left
+// untouched.
+func (d DockerOptions) shareNamespaces() DockerOptions {
+	if d.PID == "" {
+		d.PID = "host"
+	}
+
+	if d.IPC == "" {
+		d.IPC = "container:" + d.ipcHolder()
+		// /dev/shm belongs to the holder
+		d.ShmSize = ""
+	}
+	return d
+}
+
+// usesIPCHolder tells whether the epochs join the IPCHolder, envoy runs in
+// docker without an explicit ipc namespace.
+func (opt SpawnOptions) usesIPCHolder() bool {
+	return opt.DockerContainer && opt.Command == "" && opt.Docker.IPC == ""
+}
+
+// networkShared tells whether every epoch is in the same network namespace.
+// Envoy hot restarts through unix domain sockets in the abstract namespace,
+// which is per network namespace.
+func (d DockerOptions) networkShared() bool {
+	return d.Network == "" || d.Network == "host" || strings.HasPrefix(d.Network, "container:")
+}
+
+func (d DockerOptions) ipcHolder() string {
+	if d.IPCHolder == "" {
+		return defaultIPCHolder
+	}
+	return d.IPCHolder
+}
+
+// holderArgs render the docker run command line of the IPCHolder.
+func (d DockerOptions) holderArgs() []string {
+	args := []string{"run", "-d", "--name", d.ipcHolder(), "--ipc", "shareable", "--network", "none"}
+	if d.ShmSize != "" {
+		args = append(args, "--shm-size", d.ShmSize)
+	}
+	return append(args, "--entrypoint", "sleep", d.image(), "infinity")
+}
+
+// startIPCHolder run the IPCHolder unless it is running already, left by the
+// supervisor whose children we adopted.
+func (d DockerOptions) startIPCHolder() error {
+	name := d.ipcHolder()
+	out, err := exec.Command("docker", "inspect", "-f", "{{.State.Running}}", name).Output()
+	if err == nil && strings.TrimSpace(string(out)) == "true" {
+		return nil
+	}
+
+	// an exited holder keeps the name
+	exec.Command("docker", "rm", "-f", name).Run()
+	if out, err := exec.Command("docker", d.holderArgs()...).CombinedOutput(); err != nil {
+		return fmt.Errorf("start ipc holder %s: %s: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	log.Printf("[INFO] started ipc holder container %s\n", name)
+	return nil
+}
+
+// removeIPCHolder remove the IPCHolder once the last epoch is gone.
+func (d DockerOptions) removeIPCHolder() {
+	name := d.ipcHolder()
+	if out, err := exec.Command("docker", "rm", "-f", name).CombinedOutput(); err != nil {
+		log.Printf("[WARN] remove ipc holder %s: %s: %s\n", name, err, strings.TrimSpace(string(out)))
+	}
+}
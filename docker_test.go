@@ -0,0 +1,110 @@
+package reenvoy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcess_commandWithDocker(t *testing.T) {
+	t.Parallel()
+
+	p := &Process{
+		ConfigPath:          "/etc/envoy",
+		DrainTimes:          10 * time.Second,
+		ParentShutdownTimes: 20 * time.Second,
+		restartEpoch:        1,
+		EnvoyOptions:        EnvoyOptions{ServiceCluster: "front"},
+	}
+
+	require.Nil(t, p.commandWithDocker())
+	assert.Equal(t, "docker", p.command)
+	assert.Equal(t, []string{
+		"run",
+		"--name", "reenvoy-1",
+		"--network", "host",
+		"-v", "/etc/envoy:/testdata",
+		"-e", "RESTART_EPOCH",
+		"envoyproxy/envoy:" + defaultDockerTag,
+		"envoy",
+		"--mode", "serve",
+		"--restart-epoch", "1",
+		"--drain-time-s", "10",
+		"--parent-shutdown-time-s", "20",
+		"-c", "/testdata/envoy.yaml",
+		"--service-cluster", "front",
+	}, p.args)
+
+	p.Docker = DockerOptions{
+		Image:         "registry.local/envoy",
+		Tag:           "v1.7.0",
+		Network:       "bridge",
+		ConfigDir:     "/etc/envoy",
+		Volumes:       []string{"/var/log/envoy:/var/log/envoy"},
+		Env:           []string{"ENVOY_UID", "LEVEL=debug"},
+		ContainerName: "envoy-{{.Epoch}}",
+		Remove:        true,
+		IPC:           "host",
+		PID:           "host",
+		CPUs:          "1.5",
+		Memory:        "512m",
+	}
+
+	require.Nil(t, p.commandWithDocker())
+	assert.Equal(t, []string{
+		"run",
+		"--rm",
+		"--name", "envoy-1",
+		"--network", "bridge",
+		"--ipc", "host",
+		"--pid", "host",
+		"--cpus", "1.5",
+		"--memory", "512m",
+		"-v", "/etc/envoy:/etc/envoy",
+		"-v", "/var/log/envoy:/var/log/envoy",
+		"-e", "RESTART_EPOCH",
+		"-e", "ENVOY_UID",
+		"-e", "LEVEL=debug",
+		"registry.local/envoy:v1.7.0",
+		"envoy",
+	}, p.args[:26])
+	assert.Contains(t, p.args, "/etc/envoy/envoy.yaml")
+}
+
+func TestDockerOptions_Validate(t *testing.T) {
+	t.Parallel()
+
+	assert.Nil(t, DockerOptions{ContainerName: "envoy-{{.Epoch}}"}.Validate())
+	assert.NotNil(t, DockerOptions{ContainerName: "envoy-{{.Epoch"}.Validate())
+	assert.NotNil(t, DockerOptions{ContainerName: "envoy-{{.Unknown}}"}.Validate())
+}
+
+func TestDockerOptions_shareNamespaces(t *testing.T) {
+	t.Parallel()
+
+	// the parent envoy is PID 1 of its own pid namespace, joining it would get
+	// the new epoch killed once the parent drained away
+	shared := DockerOptions{ShmSize: "1g"}.shareNamespaces()
+	assert.Equal(t, "container:reenvoy-ipc", shared.IPC)
+	assert.Equal(t, "host", shared.PID)
+	assert.Empty(t, shared.ShmSize, "set on the holder")
+
+	shared = DockerOptions{IPCHolder: "front-ipc"}.shareNamespaces()
+	assert.Equal(t, "container:front-ipc", shared.IPC)
+
+	explicit := DockerOptions{IPC: "host", PID: "container:pause", ShmSize: "1g"}.shareNamespaces()
+	assert.Equal(t, "host", explicit.IPC)
+	assert.Equal(t, "container:pause", explicit.PID)
+	assert.Equal(t, "1g", explicit.ShmSize)
+}
+
+func TestDockerOptions_networkShared(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, DockerOptions{}.networkShared())
+	assert.True(t, DockerOptions{Network: "host"}.networkShared())
+	assert.True(t, DockerOptions{Network: "container:front-net"}.networkShared())
+	assert.False(t, DockerOptions{Network: "bridge"}.networkShared())
+}
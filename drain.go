@@ -0,0 +1,46 @@
+package reenvoy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// ErrNoAdminAddress is returned when the envoy admin is needed but
+// SpawnOptions.AdminAddress is not set.
+var ErrNoAdminAddress = errors.New("no envoy admin address")
+
+// DrainListeners ask the newest epoch to gracefully drain its listeners
+// through the envoy admin /drain_listeners, only the inbound ones with
+// inboundOnly. Envoy keeps running, it is up to the caller to stop it once
+// drained.
+func (r *Reenvoy) DrainListeners(inboundOnly bool) error {
+	if r.options().AdminAddress == "" {
+		return ErrNoAdminAddress
+	}
+
+	epoch := r.epochs.current()
+	if epoch == nil {
+		return errors.New("no epoch is running")
+	}
+
+	probe := newAdminProbe(r.options().AdminAddress, epoch.Epoch)
+	url := probe.address + "/drain_listeners?graceful"
+	if inboundOnly {
+		url += "&inboundonly"
+	}
+
+	resp, err := probe.client.Post(url, "text/plain", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("envoy admin /drain_listeners returned %s", resp.Status)
+	}
+
+	log.Printf("[INFO] draining the listeners of PID=%v at epoch %v\n", epoch.PID, epoch.Epoch)
+	return nil
+}
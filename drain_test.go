@@ -0,0 +1,35 @@
+package reenvoy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReenvoy_DrainListeners(t *testing.T) {
+	var query string
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost || req.URL.Path != "/drain_listeners" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		query = req.URL.RawQuery
+	}))
+	defer admin.Close()
+
+	r := newReenvoy(SpawnOptions{})
+	assert.Equal(t, ErrNoAdminAddress, r.DrainListeners(false))
+
+	r.Options.AdminAddress = admin.URL
+	assert.NotNil(t, r.DrainListeners(false), "no epoch running")
+
+	r.epochs.add(&Epoch{PID: 1, Epoch: 3, State: StateRunning})
+	require.Nil(t, r.DrainListeners(false))
+	assert.Equal(t, "graceful", query)
+
+	require.Nil(t, r.DrainListeners(true))
+	assert.Equal(t, "graceful&inboundonly", query)
+}
@@ -0,0 +1,106 @@
+package reenvoy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defaultEnvoyBinary is the envoy binary looked up in $PATH when no
+// BinaryPath is given.
+const defaultEnvoyBinary = "envoy"
+
+// EnvoyOptions are the Envoy command line options that are not managed by
+// reenvoy. Zero values are left out of the command line so Envoy use its own
+// defaults.
+// Readmore at https://www.envoyproxy.io/docs/envoy/latest/operations/cli
+type EnvoyOptions struct {
+	// ServiceCluster, ServiceNode and ServiceZone identify the local node.
+	ServiceCluster string
+	ServiceNode    string
+	ServiceZone    string
+
+	// LogLevel is one of trace, debug, info, warning, warn, error, critical
+	// or off.
+	LogLevel string
+
+	// Concurrency is the number of worker threads.
+	Concurrency int
+
+	// BaseID is used to run several Envoy hot restart families on one host.
+	BaseID int
+}
+
+var envoyLogLevels = []string{"trace", "debug", "info", "warning", "warn", "error", "critical", "off"}
+
+// managedEnvoyFlags are rendered by reenvoy itself and can not be given in
+// ExtraArgs.
+var managedEnvoyFlags = []string{
+	"--mode",
+	"--restart-epoch",
+	"--drain-time-s",
+	"--parent-shutdown-time-s",
+	"-c",
+	"--config-path",
+}
+
+// Validate check the options can be rendered into a valid command line.
+func (o EnvoyOptions) Validate() error {
+	if o.LogLevel != "" && !contains(envoyLogLevels, o.LogLevel) {
+		return fmt.Errorf("invalid envoy log level %q, expected one of %s", o.LogLevel, strings.Join(envoyLogLevels, ", "))
+	}
+
+	if o.Concurrency < 0 {
+		return fmt.Errorf("invalid envoy concurrency %d", o.Concurrency)
+	}
+
+	if o.BaseID < 0 {
+		return fmt.Errorf("invalid envoy base id %d", o.BaseID)
+	}
+	return nil
+}
+
+// args render the options as Envoy command line arguments.
+func (o EnvoyOptions) args() []string {
+	var args []string
+	if o.ServiceCluster != "" {
+		args = append(args, "--service-cluster", o.ServiceCluster)
+	}
+	if o.ServiceNode != "" {
+		args = append(args, "--service-node", o.ServiceNode)
+	}
+	if o.ServiceZone != "" {
+		args = append(args, "--service-zone", o.ServiceZone)
+	}
+	if o.LogLevel != "" {
+		args = append(args, "--log-level", o.LogLevel)
+	}
+	if o.Concurrency > 0 {
+		args = append(args, "--concurrency", strconv.Itoa(o.Concurrency))
+	}
+	if o.BaseID > 0 {
+		args = append(args, "--base-id", strconv.Itoa(o.BaseID))
+	}
+	return args
+}
+
+// validateExtraArgs refuse the flags reenvoy already renders, Envoy would get
+// them twice.
+func validateExtraArgs(args []string) error {
+	for _, arg := range args {
+		flag := strings.SplitN(arg, "=", 2)[0]
+		if contains(managedEnvoyFlags, flag) {
+			return fmt.Errorf("envoy flag %s is managed by reenvoy and can not be passed as an extra arg", flag)
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
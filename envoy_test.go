@@ -0,0 +1,59 @@
+package reenvoy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvoyOptions_Validate(t *testing.T) {
+	t.Parallel()
+
+	assert.Nil(t, EnvoyOptions{}.Validate())
+	assert.Nil(t, EnvoyOptions{LogLevel: "debug", Concurrency: 2, BaseID: 1}.Validate())
+	assert.NotNil(t, EnvoyOptions{LogLevel: "loud"}.Validate())
+	assert.NotNil(t, EnvoyOptions{Concurrency: -1}.Validate())
+	assert.NotNil(t, EnvoyOptions{BaseID: -1}.Validate())
+
+	assert.Nil(t, validateExtraArgs([]string{"--disable-hot-restart"}))
+	assert.NotNil(t, validateExtraArgs([]string{"--restart-epoch", "3"}))
+	assert.NotNil(t, validateExtraArgs([]string{"--config-path=/etc/envoy.yaml"}))
+}
+
+func TestProcess_commandEnvoy(t *testing.T) {
+	t.Parallel()
+
+	p := &Process{
+		ConfigPath:          "/etc/envoy",
+		BinaryPath:          "/opt/envoy/bin/envoy",
+		DrainTimes:          10 * time.Second,
+		ParentShutdownTimes: 20 * time.Second,
+		restartEpoch:        2,
+		EnvoyOptions: EnvoyOptions{
+			ServiceCluster: "front",
+			ServiceNode:    "node-1",
+			LogLevel:       "info",
+			Concurrency:    4,
+			BaseID:         7,
+		},
+		ExtraArgs: []string{"--disable-hot-restart"},
+	}
+
+	p.commandEnvoy()
+	assert.Equal(t, "/opt/envoy/bin/envoy", p.command)
+	assert.Equal(t, []string{
+		"--mode", "serve",
+		"--restart-epoch", "2",
+		"--drain-time-s", "10",
+		"--parent-shutdown-time-s", "20",
+		"-c", "/etc/envoy/envoy.yaml",
+		"--service-cluster", "front",
+		"--service-node", "node-1",
+		"--log-level", "info",
+		"--concurrency", "4",
+		"--base-id", "7",
+		"--disable-hot-restart",
+	}, p.args)
+
+}
@@ -0,0 +1,144 @@
+package reenvoy
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ChildState is the state of a child known by Reenvoy.
+type ChildState int
+
+const (
+	// StateRunning the child is the newest epoch.
+	StateRunning ChildState = iota
+	// StateStarting the child was started by a restart and is not healthy yet,
+	// its exit is not fatal, the restart is rolled back instead.
+	StateStarting
+	// StateDraining the child was replaced by a newer epoch and is waiting
+	// for ParentShutdownTimes before exiting.
+	StateDraining
+	// StateExited the child exited cleanly.
+	StateExited
+	// StateFailed the child exited with a non-zero code, was killed by a
+	// signal or could not be started.
+	StateFailed
+)
+
+func (s ChildState) String() string {
+	switch s {
+	case StateRunning:
+		return "running"
+	case StateStarting:
+		return "starting"
+	case StateDraining:
+		return "draining"
+	case StateExited:
+		return "exited"
+	case StateFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// Epoch describe a live child and the restart epoch it was started with.
+type Epoch struct {
+	Child     Child
+	PID       PID
+	Epoch     int
+	StartedAt time.Time
+	State     ChildState
+
+	// HotRestartVersion is the envoy --hot-restart-version of the binary the
+	// child runs, empty when unknown.
+	HotRestartVersion string
+}
+
+// epochRegistry keeps every live child ordered by epoch, so overlapping
+// restarts don't orphan an older epoch.
+type epochRegistry struct {
+	sync.RWMutex
+	epochs []*Epoch
+}
+
+// add register a new epoch, every older epoch is now draining.
+func (e *epochRegistry) add(epoch *Epoch) {
+	e.Lock()
+	defer e.Unlock()
+
+	for _, old := range e.epochs {
+		old.State = StateDraining
+	}
+
+	e.epochs = append(e.epochs, epoch)
+	sort.SliceStable(e.epochs, func(i, j int) bool {
+		return e.epochs[i].Epoch < e.epochs[j].Epoch
+	})
+}
+
+// remove forget the child with the given pid and return it, nil when unknown.
+func (e *epochRegistry) remove(pid PID) *Epoch {
+	e.Lock()
+	defer e.Unlock()
+
+	for i, epoch := range e.epochs {
+		if epoch.PID == pid {
+			e.epochs = append(e.epochs[:i], e.epochs[i+1:]...)
+			return epoch
+		}
+	}
+	return nil
+}
+
+// setState change the state of the child with the given pid.
+func (e *epochRegistry) setState(pid PID, state ChildState) {
+	e.Lock()
+	defer e.Unlock()
+
+	for _, epoch := range e.epochs {
+		if epoch.PID == pid {
+			epoch.State = state
+		}
+	}
+}
+
+// current return the newest epoch, nil when no child is alive.
+func (e *epochRegistry) current() *Epoch {
+	e.RLock()
+	defer e.RUnlock()
+
+	if len(e.epochs) == 0 {
+		return nil
+	}
+	return e.epochs[len(e.epochs)-1]
+}
+
+// currentCopy return a copy of the newest epoch, whose state can be read
+// while it changes. false when no child is alive.
+func (e *epochRegistry) currentCopy() (Epoch, bool) {
+	e.RLock()
+	defer e.RUnlock()
+
+	if len(e.epochs) == 0 {
+		return Epoch{}, false
+	}
+	return *e.epochs[len(e.epochs)-1], true
+}
+
+// list return a copy of every live epoch, oldest first.
+func (e *epochRegistry) list() []Epoch {
+	e.RLock()
+	defer e.RUnlock()
+
+	epochs := make([]Epoch, 0, len(e.epochs))
+	for _, epoch := range e.epochs {
+		epochs = append(epochs, *epoch)
+	}
+	return epochs
+}
+
+func (e *epochRegistry) len() int {
+	e.RLock()
+	defer e.RUnlock()
+	return len(e.epochs)
+}
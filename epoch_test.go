@@ -0,0 +1,36 @@
+package reenvoy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEpochRegistry(t *testing.T) {
+	t.Parallel()
+
+	var e epochRegistry
+	require.Nil(t, e.current())
+
+	e.add(&Epoch{PID: 10, Epoch: 0})
+	e.add(&Epoch{PID: 11, Epoch: 1})
+	e.add(&Epoch{PID: 12, Epoch: 2})
+
+	epochs := e.list()
+	require.Len(t, epochs, 3)
+	assert.Equal(t, StateDraining, epochs[0].State)
+	assert.Equal(t, StateDraining, epochs[1].State)
+	assert.Equal(t, StateRunning, epochs[2].State)
+	assert.Equal(t, PID(12), e.current().PID)
+
+	removed := e.remove(11)
+	require.NotNil(t, removed)
+	assert.Equal(t, 1, removed.Epoch)
+	assert.Nil(t, e.remove(11))
+
+	epochs = e.list()
+	require.Len(t, epochs, 2)
+	assert.Equal(t, PID(10), epochs[0].PID)
+	assert.Equal(t, PID(12), epochs[1].PID)
+}
@@ -0,0 +1,184 @@
+package reenvoy
+
+import (
+	"log"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// eventsBuffer is the number of events kept for a slow subscriber, older
+// events are dropped once its buffer is full.
+const eventsBuffer = 64
+
+// EventType tells what happened to the supervisor or one of its epochs.
+type EventType int
+
+const (
+	// EpochSpawned a new epoch was started, it is not healthy yet.
+	EpochSpawned EventType = iota
+	// EpochReady the epoch is healthy and took over.
+	EpochReady
+	// RestartRequested a restart is about to run.
+	RestartRequested
+	// RestartFailed a restart was refused, failed or rolled back.
+	RestartFailed
+	// ParentDrainStarted the parent of a ready epoch is draining.
+	ParentDrainStarted
+	// ParentExited a draining epoch went away.
+	ParentExited
+	// ChildCrashed an epoch exited with a non-zero code or was killed by a
+	// signal while it was not asked to stop.
+	ChildCrashed
+	// ShutdownComplete every child is gone for good, it is the last event.
+	ShutdownComplete
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EpochSpawned:
+		return "epoch_spawned"
+	case EpochReady:
+		return "epoch_ready"
+	case RestartRequested:
+		return "restart_requested"
+	case RestartFailed:
+		return "restart_failed"
+	case ParentDrainStarted:
+		return "parent_drain_started"
+	case ParentExited:
+		return "parent_exited"
+	case ChildCrashed:
+		return "child_crashed"
+	case ShutdownComplete:
+		return "shutdown_complete"
+	}
+	return "unknown"
+}
+
+// Event describe a lifecycle change. PID, Epoch and StartedAt are those of the
+// epoch the event is about, when there is one.
+type Event struct {
+	Type      EventType
+	Time      time.Time
+	PID       PID
+	Epoch     int
+	StartedAt time.Time
+
+	// ExitCode and Signal tell how the epoch went away, for ParentExited and
+	// ChildCrashed.
+	ExitCode int
+	Signal   syscall.Signal
+
+	Err error
+}
+
+// epochEvent return an event about epoch.
+func epochEvent(t EventType, epoch *Epoch) Event {
+	return Event{
+		Type:      t,
+		Time:      time.Now(),
+		PID:       epoch.PID,
+		Epoch:     epoch.Epoch,
+		StartedAt: epoch.StartedAt,
+	}
+}
+
+// eventBroker fan the events out to every subscriber. Publishing never blocks,
+// a subscriber not keeping up loses its oldest events.
+type eventBroker struct {
+	sync.Mutex
+	subscribers map[chan Event]struct{}
+	closed      bool
+}
+
+func (b *eventBroker) subscribe() chan Event {
+	b.Lock()
+	defer b.Unlock()
+
+	ch := make(chan Event, eventsBuffer)
+	if b.closed {
+		close(ch)
+		return ch
+	}
+
+	if b.subscribers == nil {
+		b.subscribers = map[chan Event]struct{}{}
+	}
+	b.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (b *eventBroker) unsubscribe(ch chan Event) {
+	b.Lock()
+	defer b.Unlock()
+
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (b *eventBroker) publish(e Event) {
+	b.Lock()
+	defer b.Unlock()
+
+	for ch := range b.subscribers {
+		for sent := false; !sent; {
+			select {
+			case ch <- e:
+				sent = true
+			default:
+				select {
+				case old := <-ch:
+					log.Printf("[WARN] dropping unread %s event for epoch %v\n", old.Type, old.Epoch)
+				default:
+				}
+			}
+		}
+	}
+}
+
+// close end every subscription, subscribing later return a closed channel.
+func (b *eventBroker) close() {
+	b.Lock()
+	defer b.Unlock()
+
+	for ch := range b.subscribers {
+		close(ch)
+	}
+	b.subscribers = nil
+	b.closed = true
+}
+
+// markReady set epoch running once healthy, parent was running before it
+// and is now draining.
+func (r *Reenvoy) markReady(epoch, parent *Epoch) {
+	r.epochs.setState(epoch.PID, StateRunning)
+	r.emit(epochEvent(EpochReady, epoch))
+	if parent != nil {
+		r.emit(epochEvent(ParentDrainStarted, parent))
+	}
+}
+
+// Events subscribe to the lifecycle events, the channel is closed after
+// ShutdownComplete or once unsubscribe is called. A subscriber not keeping
+// up loses its oldest events, it never blocks the supervisor.
+func (r *Reenvoy) Events() (<-chan Event, func()) {
+	ch := r.events.subscribe()
+	return ch, func() { r.events.unsubscribe(ch) }
+}
+
+// emit publish e to the subscribers. Errors are also sent to Options.ErrCh
+// when nobody is reading it already.
+func (r *Reenvoy) emit(e Event) {
+	r.metrics.observe(e)
+	r.events.publish(e)
+
+	if errCh := r.options().ErrCh; e.Err != nil && errCh != nil {
+		select {
+		case errCh <- e.Err:
+		default:
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package reenvoy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventBroker(t *testing.T) {
+	t.Parallel()
+
+	var b eventBroker
+	slow := b.subscribe()
+	gone := b.subscribe()
+	b.unsubscribe(gone)
+
+	// the slow subscriber keeps the newest events, publishing never blocks
+	for i := 0; i < eventsBuffer+10; i++ {
+		b.publish(Event{Type: EpochSpawned, Epoch: i})
+	}
+	assert.Len(t, slow, eventsBuffer)
+	assert.Equal(t, 10, (<-slow).Epoch)
+
+	_, open := <-gone
+	assert.False(t, open)
+
+	b.close()
+	for range slow {
+	}
+	_, open = <-b.subscribe()
+	assert.False(t, open)
+}
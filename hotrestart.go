@@ -0,0 +1,134 @@
+package reenvoy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+// HotRestartPolicy tells what a restart does when the new envoy has another
+// hot restart version than the running epoch, envoy can't hot restart
+// between them.
+type HotRestartPolicy int
+
+const (
+	// HotRestartCold stop every epoch and start the new envoy at epoch 0.
+	HotRestartCold HotRestartPolicy = iota
+	// HotRestartRefuse keep the running epoch and fail the restart.
+	HotRestartRefuse
+	// HotRestartWarn hot restart anyway, the new epoch will most likely fail
+	// to start and be rolled back.
+	HotRestartWarn
+)
+
+func (p HotRestartPolicy) String() string {
+	switch p {
+	case HotRestartCold:
+		return "cold"
+	case HotRestartRefuse:
+		return "refuse"
+	case HotRestartWarn:
+		return "warn"
+	}
+	return "unknown"
+}
+
+// HotRestartVersionError is returned by a restart to an envoy with another
+// hot restart version than the running one. With HotRestartCold envoy was
+// stopped and started again instead, Err tells whether that failed.
+type HotRestartVersionError struct {
+	Current string
+	New     string
+	Policy  HotRestartPolicy
+	Err     error
+}
+
+func (e *HotRestartVersionError) Error() string {
+	msg := fmt.Sprintf("hot restart version changed from %q to %q", e.Current, e.New)
+	if e.Policy != HotRestartCold {
+		return msg + ", not restarting"
+	}
+
+	msg += ", envoy was cold restarted"
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", msg, e.Err)
+	}
+	return msg
+}
+
+// hotRestartVersion ask the envoy binary or image of opt for its hot restart
+// version with envoy --hot-restart-version.
+func hotRestartVersion(opt SpawnOptions) (string, error) {
+	if opt.Command != "" {
+		return "", errors.New("hot restart version is only known for envoy")
+	}
+
+	command := opt.BinaryPath
+	if command == "" {
+		command = defaultEnvoyBinary
+	}
+	args := []string{"--hot-restart-version"}
+	if opt.DockerContainer {
+		command = "docker"
+		args = []string{"run", "--rm", opt.Docker.image(), defaultEnvoyBinary, "--hot-restart-version"}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), validateTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Env = opt.Env
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s --hot-restart-version: %s", command, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// knownHotRestartVersion is hotRestartVersion when it can be told, empty
+// otherwise.
+func knownHotRestartVersion(opt SpawnOptions) string {
+	if opt.Command != "" {
+		return ""
+	}
+
+	version, err := hotRestartVersion(opt)
+	if err != nil {
+		log.Println("[WARN] hot restart version unknown:", err)
+	}
+	return version
+}
+
+// checkHotRestart return the hot restart version of the envoy opt starts and
+// compare it with the running epoch. A mismatch is reported as a
+// *HotRestartVersionError unless the policy is HotRestartWarn. Nothing is
+// checked when supervising a Command or the running version is unknown.
+func (r *Reenvoy) checkHotRestart(opt SpawnOptions) (string, error) {
+	if opt.Command != "" {
+		return "", nil
+	}
+
+	version, err := hotRestartVersion(opt)
+	if err != nil {
+		return "", err
+	}
+
+	current := r.epochs.current()
+	if current == nil || current.HotRestartVersion == "" || current.HotRestartVersion == version {
+		return version, nil
+	}
+
+	if opt.HotRestartMismatch == HotRestartWarn {
+		log.Printf("[WARN] hot restart version changed from %s to %s, hot restarting anyway\n", current.HotRestartVersion, version)
+		return version, nil
+	}
+
+	return version, &HotRestartVersionError{
+		Current: current.HotRestartVersion,
+		New:     version,
+		Policy:  opt.HotRestartMismatch,
+	}
+}
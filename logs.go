@@ -0,0 +1,119 @@
+package reenvoy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// logBufferSize is how much of the output of every epoch is kept.
+	logBufferSize = 64 << 10
+	// logEpochsKept is the number of epochs the output is kept of, exited
+	// ones included so a crash can be looked into.
+	logEpochsKept = 8
+)
+
+// ErrLogsNotCaptured is returned by Logs when SpawnOptions.CaptureLogs is not
+// set.
+var ErrLogsNotCaptured = errors.New("logs are not captured")
+
+// logBuffer keep the last logBufferSize bytes written to it.
+type logBuffer struct {
+	sync.Mutex
+	buf  []byte
+	next int
+	full bool
+}
+
+func newLogBuffer(size int) *logBuffer {
+	return &logBuffer{buf: make([]byte, size)}
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	n := len(p)
+	if len(p) > len(b.buf) {
+		p = p[len(p)-len(b.buf):]
+	}
+	for len(p) > 0 {
+		copied := copy(b.buf[b.next:], p)
+		p = p[copied:]
+		if b.next += copied; b.next == len(b.buf) {
+			b.next = 0
+			b.full = true
+		}
+	}
+	return n, nil
+}
+
+// Bytes return what is kept, oldest first.
+func (b *logBuffer) Bytes() []byte {
+	b.Lock()
+	defer b.Unlock()
+
+	if !b.full {
+		return append([]byte(nil), b.buf[:b.next]...)
+	}
+	return append(append([]byte(nil), b.buf[b.next:]...), b.buf[:b.next]...)
+}
+
+// epochLogs keep the output of the last logEpochsKept epochs.
+type epochLogs struct {
+	sync.Mutex
+	buffers map[int]*logBuffer
+	epochs  []int
+}
+
+// add return a new buffer for epoch, the buffer of the oldest epoch is
+// forgotten once there are too many.
+func (l *epochLogs) add(epoch int) *logBuffer {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.buffers == nil {
+		l.buffers = map[int]*logBuffer{}
+	}
+	if _, ok := l.buffers[epoch]; !ok {
+		l.epochs = append(l.epochs, epoch)
+	}
+	l.buffers[epoch] = newLogBuffer(logBufferSize)
+
+	for len(l.epochs) > logEpochsKept {
+		delete(l.buffers, l.epochs[0])
+		l.epochs = l.epochs[1:]
+	}
+	return l.buffers[epoch]
+}
+
+func (l *epochLogs) get(epoch int) (*logBuffer, bool) {
+	l.Lock()
+	defer l.Unlock()
+	b, ok := l.buffers[epoch]
+	return b, ok
+}
+
+// teeWriter write to w, when given, and to the buffer.
+func teeWriter(w io.Writer, b *logBuffer) io.Writer {
+	if w == nil {
+		return b
+	}
+	return io.MultiWriter(w, b)
+}
+
+// Logs return the last output, stdout and stderr, of the epoch. The output of
+// the last few epochs is kept, exited ones included.
+func (r *Reenvoy) Logs(epoch int) ([]byte, error) {
+	if !r.options().CaptureLogs {
+		return nil, ErrLogsNotCaptured
+	}
+
+	b, ok := r.logs.get(epoch)
+	if !ok {
+		return nil, fmt.Errorf("no logs kept for epoch %v", epoch)
+	}
+	return b.Bytes(), nil
+}
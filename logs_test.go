@@ -0,0 +1,61 @@
+package reenvoy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogBuffer(t *testing.T) {
+	b := newLogBuffer(8)
+	assert.Empty(t, b.Bytes())
+
+	b.Write([]byte("abc"))
+	assert.Equal(t, "abc", string(b.Bytes()))
+
+	b.Write([]byte("defgh"))
+	assert.Equal(t, "abcdefgh", string(b.Bytes()))
+
+	n, err := b.Write([]byte("ijk"))
+	require.Nil(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, "defghijk", string(b.Bytes()))
+
+	n, _ = b.Write([]byte("0123456789"))
+	assert.Equal(t, 10, n)
+	assert.Equal(t, "23456789", string(b.Bytes()))
+}
+
+func TestEpochLogs(t *testing.T) {
+	l := epochLogs{}
+	for epoch := 0; epoch < logEpochsKept+2; epoch++ {
+		l.add(epoch).Write([]byte("epoch"))
+	}
+
+	_, ok := l.get(1)
+	assert.False(t, ok, "oldest epochs are forgotten")
+
+	b, ok := l.get(2)
+	require.True(t, ok)
+	assert.Equal(t, "epoch", string(b.Bytes()))
+
+	// a failed spawn hands its epoch to the next one
+	l.add(logEpochsKept + 1)
+	b, _ = l.get(logEpochsKept + 1)
+	assert.Empty(t, b.Bytes())
+	assert.Len(t, l.epochs, logEpochsKept)
+}
+
+func TestReenvoy_LogsNotCaptured(t *testing.T) {
+	r := newReenvoy(SpawnOptions{})
+	r.logs.add(0).Write([]byte("kept anyway"))
+
+	_, err := r.Logs(0)
+	assert.Equal(t, ErrLogsNotCaptured, err)
+
+	r.Options.CaptureLogs = true
+	logs, err := r.Logs(0)
+	require.Nil(t, err)
+	assert.Equal(t, "kept anyway", string(logs))
+}
@@ -0,0 +1,235 @@
+package reenvoy
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// readyBuckets and drainBuckets are the histogram buckets, in seconds, of
+	// the spawn to ready latency and of the drain duration.
+	readyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+	drainBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 900}
+
+	// restartOutcomes are the outcomes a restart is counted by.
+	restartOutcomes = []string{"success", "failed", "rolled_back", "cold_restart", "throttled"}
+)
+
+// histogram is a prometheus histogram, counts[i] is the number of
+// observations lower or equal to buckets[i].
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bucket := range h.buckets {
+		if v <= bucket {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) write(w io.Writer, name string) {
+	for i, bucket := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(bucket), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// crashKey is the labels a crash is counted by.
+type crashKey struct {
+	code   int
+	signal int
+}
+
+// metrics collect the supervisor metrics, fed by the lifecycle events. The
+// gauges are read from Reenvoy when scraped.
+type metrics struct {
+	sync.Mutex
+
+	restarts           map[string]uint64
+	crashes            map[crashKey]uint64
+	validationFailures uint64
+	ready              *histogram
+	drain              *histogram
+
+	// draining holds when every draining epoch started to drain.
+	draining map[PID]time.Time
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		restarts: map[string]uint64{},
+		crashes:  map[crashKey]uint64{},
+		ready:    newHistogram(readyBuckets),
+		drain:    newHistogram(drainBuckets),
+		draining: map[PID]time.Time{},
+	}
+}
+
+// restartOutcome tells how a restart ended, one of restartOutcomes.
+func restartOutcome(res RestartResult) string {
+	switch err := res.Err.(type) {
+	case nil:
+		return "success"
+	case *HotRestartVersionError:
+		if err.Policy == HotRestartCold && err.Err == nil {
+			return "cold_restart"
+		}
+	}
+
+	switch {
+	case res.Err == ErrRestartThrottled:
+		return "throttled"
+	case res.RolledBack:
+		return "rolled_back"
+	}
+	return "failed"
+}
+
+func (m *metrics) restarted(res RestartResult) {
+	m.Lock()
+	defer m.Unlock()
+	m.restarts[restartOutcome(res)]++
+}
+
+func (m *metrics) validationFailed() {
+	m.Lock()
+	defer m.Unlock()
+	m.validationFailures++
+}
+
+// observe update the metrics from a lifecycle event.
+func (m *metrics) observe(e Event) {
+	m.Lock()
+	defer m.Unlock()
+
+	switch e.Type {
+	case EpochReady:
+		if !e.StartedAt.IsZero() {
+			m.ready.observe(e.Time.Sub(e.StartedAt).Seconds())
+		}
+	case ParentDrainStarted:
+		m.draining[e.PID] = e.Time
+	case ParentExited, ChildCrashed:
+		if started, ok := m.draining[e.PID]; ok {
+			m.drain.observe(e.Time.Sub(started).Seconds())
+			delete(m.draining, e.PID)
+		}
+		if e.Type == ChildCrashed {
+			m.crashes[crashKey{code: e.ExitCode, signal: int(e.Signal)}]++
+		}
+	}
+}
+
+// write render the metrics in the prometheus text format, epoch and children
+// are the current gauges.
+func (m *metrics) write(w io.Writer, epoch int, children map[ChildState]int) {
+	m.Lock()
+	defer m.Unlock()
+
+	fmt.Fprintln(w, "# HELP reenvoy_restarts_total Restarts by outcome.")
+	fmt.Fprintln(w, "# TYPE reenvoy_restarts_total counter")
+	for _, outcome := range restartOutcomes {
+		fmt.Fprintf(w, "reenvoy_restarts_total{outcome=%q} %d\n", outcome, m.restarts[outcome])
+	}
+
+	fmt.Fprintln(w, "# HELP reenvoy_epoch Restart epoch of the newest live child, -1 when none.")
+	fmt.Fprintln(w, "# TYPE reenvoy_epoch gauge")
+	fmt.Fprintf(w, "reenvoy_epoch %d\n", epoch)
+
+	fmt.Fprintln(w, "# HELP reenvoy_children Live children by state.")
+	fmt.Fprintln(w, "# TYPE reenvoy_children gauge")
+	for _, state := range []ChildState{StateStarting, StateRunning, StateDraining} {
+		fmt.Fprintf(w, "reenvoy_children{state=%q} %d\n", state, children[state])
+	}
+
+	fmt.Fprintln(w, "# HELP reenvoy_ready_seconds Time from spawning an epoch to it being ready.")
+	fmt.Fprintln(w, "# TYPE reenvoy_ready_seconds histogram")
+	m.ready.write(w, "reenvoy_ready_seconds")
+
+	fmt.Fprintln(w, "# HELP reenvoy_drain_seconds Time a parent epoch took to exit once draining.")
+	fmt.Fprintln(w, "# TYPE reenvoy_drain_seconds histogram")
+	m.drain.write(w, "reenvoy_drain_seconds")
+
+	fmt.Fprintln(w, "# HELP reenvoy_crashes_total Children crashed by exit code and signal.")
+	fmt.Fprintln(w, "# TYPE reenvoy_crashes_total counter")
+	crashes := make([]crashKey, 0, len(m.crashes))
+	for key := range m.crashes {
+		crashes = append(crashes, key)
+	}
+	sort.Slice(crashes, func(i, j int) bool {
+		if crashes[i].code != crashes[j].code {
+			return crashes[i].code < crashes[j].code
+		}
+		return crashes[i].signal < crashes[j].signal
+	})
+	for _, key := range crashes {
+		fmt.Fprintf(w, "reenvoy_crashes_total{code=\"%d\",signal=\"%d\"} %d\n", key.code, key.signal, m.crashes[key])
+	}
+
+	fmt.Fprintln(w, "# HELP reenvoy_config_validation_failures_total Configs refused by envoy --mode validate.")
+	fmt.Fprintln(w, "# TYPE reenvoy_config_validation_failures_total counter")
+	fmt.Fprintf(w, "reenvoy_config_validation_failures_total %d\n", m.validationFailures)
+}
+
+// MetricsHandler serve the supervisor metrics in the prometheus text format.
+func (r *Reenvoy) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		children := map[ChildState]int{}
+		for _, epoch := range r.epochs.list() {
+			children[epoch.State]++
+		}
+
+		var b strings.Builder
+		r.metrics.write(&b, r.Epoch(), children)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		io.WriteString(w, b.String())
+	})
+}
+
+// serveMetrics serve MetricsHandler on /metrics at Options.MetricsAddress
+// until every child is gone.
+func (r *Reenvoy) serveMetrics() error {
+	ln, err := net.Listen("tcp", r.options().MetricsAddress)
+	if err != nil {
+		return fmt.Errorf("metrics listener: %s", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r.MetricsHandler())
+	srv := &http.Server{Handler: mux}
+	r.listeners = append(r.listeners, ln)
+
+	log.Printf("[INFO] serving metrics on http://%s/metrics\n", ln.Addr())
+	go srv.Serve(ln)
+	go func() {
+		<-r.done
+		srv.Close()
+	}()
+	return nil
+}
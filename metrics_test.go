@@ -0,0 +1,70 @@
+package reenvoy
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	m := newMetrics()
+	now := time.Now()
+
+	m.restarted(RestartResult{})
+	m.restarted(RestartResult{Err: ErrRestartThrottled})
+	m.restarted(RestartResult{Err: errors.New("rolled back"), RolledBack: true})
+	m.restarted(RestartResult{Err: &HotRestartVersionError{Policy: HotRestartCold}})
+	m.validationFailed()
+
+	m.observe(Event{Type: EpochReady, Time: now, StartedAt: now.Add(-300 * time.Millisecond)})
+	m.observe(Event{Type: ParentDrainStarted, PID: 10, Time: now})
+	m.observe(Event{Type: ParentExited, PID: 10, Time: now.Add(20 * time.Second)})
+	m.observe(Event{Type: ChildCrashed, PID: 11, ExitCode: 3})
+	m.observe(Event{Type: ChildCrashed, PID: 12, ExitCode: -1, Signal: syscall.SIGKILL})
+
+	var b strings.Builder
+	m.write(&b, 2, map[ChildState]int{StateRunning: 1, StateDraining: 1})
+	out := b.String()
+
+	for _, line := range []string{
+		`reenvoy_restarts_total{outcome="success"} 1`,
+		`reenvoy_restarts_total{outcome="failed"} 0`,
+		`reenvoy_restarts_total{outcome="rolled_back"} 1`,
+		`reenvoy_restarts_total{outcome="cold_restart"} 1`,
+		`reenvoy_restarts_total{outcome="throttled"} 1`,
+		`reenvoy_epoch 2`,
+		`reenvoy_children{state="running"} 1`,
+		`reenvoy_children{state="draining"} 1`,
+		`reenvoy_ready_seconds_bucket{le="0.25"} 0`,
+		`reenvoy_ready_seconds_bucket{le="0.5"} 1`,
+		`reenvoy_ready_seconds_bucket{le="+Inf"} 1`,
+		`reenvoy_ready_seconds_count 1`,
+		`reenvoy_drain_seconds_bucket{le="10"} 0`,
+		`reenvoy_drain_seconds_bucket{le="30"} 1`,
+		`reenvoy_drain_seconds_sum 20`,
+		`reenvoy_crashes_total{code="-1",signal="9"} 1`,
+		`reenvoy_crashes_total{code="3",signal="0"} 1`,
+		`reenvoy_config_validation_failures_total 1`,
+	} {
+		assert.Contains(t, out, line+"\n")
+	}
+}
+
+func TestReenvoy_MetricsHandler(t *testing.T) {
+	t.Parallel()
+
+	r := newReenvoy(SpawnOptions{})
+	rec := httptest.NewRecorder()
+	r.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, 200, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/plain")
+	assert.Contains(t, rec.Body.String(), "reenvoy_epoch -1\n")
+}
@@ -33,6 +33,20 @@ var (
 	ExitCodeError = 127
 )
 
+// outputWaitDelay is how long the output of an exited child is still copied
+// while a grandchild holds it open.
+const outputWaitDelay = time.Second
+
+// copiedOutput tells whether exec copies what the child writes to w through a
+// pipe, any writer but a file handed to the child as is.
+func copiedOutput(w io.Writer) bool {
+	if w == nil {
+		return false
+	}
+	_, ok := w.(*os.File)
+	return !ok
+}
+
 type Child interface {
 	Restart() error
 	Stop()
@@ -40,6 +54,10 @@ type Child interface {
 	Start() error
 	ProcessState() *os.ProcessState
 	GetPID() PID
+	Exited() <-chan struct{}
+	ExitStatus() (code int, signal syscall.Signal)
+	Signal(s os.Signal) error
+	Status() Status
 }
 
 type Process struct {
@@ -50,7 +68,12 @@ type Process struct {
 	DoneCh chan struct{}
 
 	// Command is the name of the command to execute. Args are the list of
-	// arguments to pass when starting the command.
+	// arguments to pass when starting the command. When Command is empty
+	// envoy is run, either natively or in docker.
+	Command string
+	Args    []string
+
+	// command and args are the command line actually run by the last start.
 	command string
 	args    []string
 
@@ -78,13 +101,28 @@ type Process struct {
 	DrainTimes time.Duration
 
 	DockerContainer bool
+	Docker          DockerOptions
 	ConfigPath      string
 	restartEpoch    int
 
-	// exec is the actual child process under management.
-	exec *exec.Cmd
+	// BinaryPath is the envoy binary to run, envoy from $PATH when empty.
+	// EnvoyOptions and ExtraArgs are added to the envoy command line.
+	BinaryPath   string
+	EnvoyOptions EnvoyOptions
+	ExtraArgs    []string
+
+	// exec is the actual child process under management. pid and startedAt
+	// describe the process started last, they are kept once it is gone.
+	// lastErr is why the last start failed.
+	exec      *exec.Cmd
+	pid       PID
+	startedAt time.Time
+	lastErr   error
 	// exitCh is the channel where the processes exit will be returned.
 	exitCh chan int
+	// exit describe how the process started last went away, it is replaced on
+	// every start so a previous process can not overwrite it.
+	exit *exitStatus
 
 	// Splay is the maximum random amount of time to wait before sending signals.
 	// This option helps reduce the thundering herd problem by effectively
@@ -135,7 +173,10 @@ func NewProc() (Child, error) {
 func (r *Process) Start() error {
 	r.Lock()
 	defer r.Unlock()
-	return r.start()
+
+	err := r.start()
+	r.lastErr = err
+	return err
 }
 
 // Restart send the reload signal to the process and does not wait for a response
@@ -164,32 +205,52 @@ func (r *Process) Restart() error {
 	return r.reload()
 }
 
-func (r *Process) commandWithDocker() {
+// exitStatus is filled by the goroutine waiting for the process, done is
+// closed once code and signal are set.
+type exitStatus struct {
+	done   chan struct{}
+	code   int
+	signal syscall.Signal
+}
+
+func (r *Process) commandWithDocker() error {
+	args, err := r.Docker.runArgs(r.ConfigPath, r.restartEpoch)
+	if err != nil {
+		return err
+	}
+
 	r.command = "docker"
-	r.args = []string{
-		"run",
-		"--network",
-		"host",
-		"-v",
-		fmt.Sprintf("%s:/testdata", r.ConfigPath),
-		envoyDockerImage,
-		"envoy",
-		"--mode",
-		"serve",
-		"--restart-epoch",
-		strconv.Itoa(r.restartEpoch),
-		"--drain-time-s",
-		fmt.Sprintf("%v", r.DrainTimes.Seconds()),
-		"--parent-shutdown-time-s",
-		fmt.Sprintf("%v", r.ParentShutdownTimes.Seconds()),
-		"-c",
-		"/testdata/envoy.yaml",
+	r.args = append(args, defaultEnvoyBinary)
+	r.args = append(r.args, r.envoyArgs(r.Docker.configDir()+"/envoy.yaml")...)
+	return nil
+}
+
+// removeStaleContainer remove the exited container left with the name we are
+// about to use, by a cold restart starting over at epoch 0 or by a previous
+// supervisor, docker refuses to run another one with the same name. A running
+// container is left alone, docker rm refuses it and run reports the conflict.
+func (r *Process) removeStaleContainer() {
+	name, err := r.Docker.containerName(r.restartEpoch)
+	if err != nil {
+		return
+	}
+
+	if err := exec.Command("docker", "rm", name).Run(); err == nil {
+		log.Printf("[INFO] removed stale container %s\n", name)
 	}
 }
 
 func (r *Process) commandEnvoy() {
-	r.command = "envoy"
-	r.args = []string{
+	r.command = r.BinaryPath
+	if r.command == "" {
+		r.command = defaultEnvoyBinary
+	}
+	r.args = r.envoyArgs(fmt.Sprintf("%s/envoy.yaml", r.ConfigPath))
+}
+
+// envoyArgs return the envoy command line serving configFile.
+func (r *Process) envoyArgs(configFile string) []string {
+	args := []string{
 		"--mode",
 		"serve",
 		"--restart-epoch",
@@ -199,14 +260,22 @@ func (r *Process) commandEnvoy() {
 		"--parent-shutdown-time-s",
 		fmt.Sprintf("%v", r.ParentShutdownTimes.Seconds()),
 		"-c",
-		fmt.Sprintf("%s/envoy.yaml", r.ConfigPath),
+		configFile,
 	}
+	args = append(args, r.EnvoyOptions.args()...)
+	return append(args, r.ExtraArgs...)
 }
 
 func (r *Process) start() error {
-	if r.DockerContainer {
-		r.commandWithDocker()
-	} else {
+	switch {
+	case r.Command != "":
+		r.command, r.args = r.Command, r.Args
+	case r.DockerContainer:
+		if err := r.commandWithDocker(); err != nil {
+			return err
+		}
+		r.removeStaleContainer()
+	default:
 		r.commandEnvoy()
 	}
 
@@ -215,44 +284,63 @@ func (r *Process) start() error {
 	cmd.Stderr = r.StdErr
 	cmd.Stdout = r.Stdout
 	cmd.Env = r.Env
+	// output copied through a pipe, a grandchild keeping it open must not
+	// hold the exit of the child back.
+	if copiedOutput(r.Stdout) || copiedOutput(r.StdErr) {
+		cmd.WaitDelay = outputWaitDelay
+	}
 
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("%s err: %s", r.StdErr, err)
 	}
 
 	r.exec = cmd
+	r.pid = PID(cmd.Process.Pid)
+	r.startedAt = time.Now()
 
 	// Create a new exitCh so that previously invoked commands (if any) don't
 	// cause us to exit, and start a goroutine to wait for that process to end.
+	// The goroutine keeps its own stopCh, ours is replaced by the next start.
 	exitCh := make(chan int, 1)
+	stopCh := make(chan struct{}, 1)
+	exit := &exitStatus{done: make(chan struct{})}
 	go func() {
 		var code int
 		err := cmd.Wait()
-		if err == nil {
+		if err == nil || err == exec.ErrWaitDelay {
 			code = ExitCodeOK
 		} else {
 			code = ExitCodeError
 			if exiterr, ok := err.(*exec.ExitError); ok {
 				if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
 					code = status.ExitStatus()
+					if status.Signaled() {
+						exit.signal = status.Signal()
+					}
 				}
 			}
 		}
+		exit.code = code
+		close(exit.done)
 
 		// If the child is in the process of killing, do not send a response back
 		// down the exit channel.
-		if r.stopped {
+		r.stopLock.RLock()
+		stopped := r.stopped
+		r.stopLock.RUnlock()
+		if stopped {
 			return
 		}
 
 		select {
-		case <-r.stopCh:
+		case <-stopCh:
 		case exitCh <- code:
 		}
 	}()
 
 	r.exitCh = exitCh
-	r.stopCh = make(chan struct{}, 1)
+	r.exit = exit
+	r.stopCh = stopCh
 
 	// If a timeout was given, start the timer to wait for the child to exit
 	if r.Timeout != 0 {
@@ -302,6 +390,12 @@ func (r *Process) reload() error {
 
 //GetPID return pid current process
 func (r *Process) GetPID() PID {
+	r.RLock()
+	defer r.RUnlock()
+	return r.getPID()
+}
+
+func (r *Process) getPID() PID {
 	if !r.running() {
 		return 0
 	}
@@ -333,32 +427,30 @@ func (r *Process) kill() {
 		return
 	}
 
-	log.Println("[INFO] kill process ", r.GetPID())
+	log.Println("[INFO] kill process ", r.getPID())
 
 	exited := false
 	process := r.exec.Process
+	done := r.exit.done
 
-	if r.exec.ProcessState == nil {
+	// ProcessState is set by the goroutine waiting for the process, done
+	// tells the same without racing it.
+	select {
+	case <-done:
+		log.Printf("[DEBUG] (runner) Kill() called but process dead; not waiting for splay.")
+	default:
 		select {
 		case <-r.stopCh:
 		case <-r.randomSplay():
 		}
-	} else {
-		log.Printf("[DEBUG] (runner) Kill() called but process dead; not waiting for splay.")
 	}
 
 	if r.KillSignal != nil {
 		if err := process.Signal(r.KillSignal); err == nil {
 			// Wait a few seconds for it to exit
-			killCh := make(chan struct{}, 1)
-			go func() {
-				defer close(killCh)
-				process.Wait()
-			}()
-
 			select {
 			case <-r.stopCh:
-			case <-killCh:
+			case <-done:
 				exited = true
 			case <-time.After(r.KillTimeout):
 			}
@@ -387,7 +479,9 @@ func (r *Process) Stop() {
 		return
 	}
 
+	r.Lock()
 	r.kill()
+	r.Unlock()
 	close(r.stopCh)
 	r.stopped = true
 }
@@ -414,18 +508,76 @@ func (r *Process) ExitCh() <-chan int {
 	return r.exitCh
 }
 
+// Exited return a channel closed once the process started last has exited,
+// nil if the process was never started.
+func (r *Process) Exited() <-chan struct{} {
+	r.RLock()
+	defer r.RUnlock()
+	if r.exit == nil {
+		return nil
+	}
+	return r.exit.done
+}
+
+// ExitStatus return the exit code of the process started last and the signal
+// that terminated it, if any. It is only meaningful once Exited is closed.
+func (r *Process) ExitStatus() (int, syscall.Signal) {
+	r.RLock()
+	defer r.RUnlock()
+	if r.exit == nil {
+		return 0, 0
+	}
+	return r.exit.code, r.exit.signal
+}
+
 //ProcessState 	contains information about an exited process,
-// available after a call to Wait or Run.
+// available after a call to Wait or Run. It is nil while the process runs and
+// once it has been killed.
 func (r *Process) ProcessState() *os.ProcessState {
+	r.RLock()
+	defer r.RUnlock()
+	if r.exec == nil {
+		return nil
+	}
 	return r.exec.ProcessState
 }
 
+// Status describe the process started last, Starting when it was never
+// started.
+func (r *Process) Status() Status {
+	r.RLock()
+	defer r.RUnlock()
+
+	status := Status{
+		State:     StateStarting,
+		PID:       r.pid,
+		Epoch:     r.restartEpoch,
+		StartedAt: r.startedAt,
+		Err:       r.lastErr,
+	}
+
+	switch {
+	case r.lastErr != nil:
+		status.State = StateFailed
+	case r.exit == nil:
+	default:
+		select {
+		case <-r.exit.done:
+			status.ExitCode, status.Signal = r.exit.code, r.exit.signal
+			status.State = exitState(status.ExitCode, status.Signal)
+		default:
+			status.State = StateRunning
+		}
+	}
+	return status
+}
+
 // Signal sends a signal to the Process, returning any errors that accur.
 // Sending Interrupt on Windows is not implemented.
 func (r *Process) Signal(s os.Signal) error {
 	log.Printf("[INFO] receiving signal %q", s.String())
 	r.RLock()
-	defer r.RLock()
+	defer r.RUnlock()
 	return r.signal(s)
 }
 
@@ -2,6 +2,8 @@ package reenvoy
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"syscall"
 	"testing"
@@ -298,3 +300,77 @@ func TestKill_noProcess(t *testing.T) {
 	c.KillSignal = syscall.SIGUSR1
 	c.Kill()
 }
+
+func TestProcess_Status(t *testing.T) {
+	t.Parallel()
+
+	c := testProcess(t)
+	c.Command = "bash"
+	c.Args = []string{"-c", "sleep 0.2; exit 3"}
+	assert.Equal(t, StateStarting, c.Status().State)
+
+	require.Nil(t, c.Start())
+	status := c.Status()
+	assert.Equal(t, StateRunning, status.State)
+	assert.NotZero(t, status.PID)
+	assert.False(t, status.StartedAt.IsZero())
+
+	<-c.Exited()
+	status = c.Status()
+	assert.Equal(t, StateFailed, status.State)
+	assert.Equal(t, 3, status.ExitCode)
+
+	// the exit is still known once killed
+	c.Kill()
+	assert.Nil(t, c.ProcessState())
+	assert.Equal(t, StateFailed, c.Status().State)
+	assert.NotZero(t, c.Status().PID)
+}
+
+func TestProcess_StatusStartError(t *testing.T) {
+	t.Parallel()
+
+	c := testProcess(t)
+	c.Command = "/does/not/exist"
+	require.NotNil(t, c.Start())
+
+	status := c.Status()
+	assert.Equal(t, StateFailed, status.State)
+	assert.NotNil(t, status.Err)
+}
+
+func TestProcess_ExitGrandchildOutput(t *testing.T) {
+	t.Parallel()
+
+	file, err := ioutil.TempFile("", "reenvoy")
+	require.Nil(t, err)
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	tests := []struct {
+		name   string
+		stdout io.Writer
+	}{
+		// the pipe stays open until the grandchild exits, it is waited on
+		// for outputWaitDelay only
+		{name: "copied", stdout: gatedio.NewByteBuffer()},
+		{name: "file", stdout: file},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testProcess(t)
+			c.Command = "bash"
+			c.Args = []string{"-c", "sleep 10 & exit 0"}
+			c.Stdout = tt.stdout
+			require.Nil(t, c.Start())
+
+			select {
+			case code := <-c.ExitCh():
+				assert.Equal(t, ExitCodeOK, code)
+			case <-time.After(outputWaitDelay + 2*time.Second):
+				t.Fatal("exit held back by the grandchild")
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package reenvoy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// readyPollInterval is how often the envoy admin is polled while waiting for
+// a new epoch to be live.
+const readyPollInterval = 200 * time.Millisecond
+
+// serverInfo is the part of the envoy admin /server_info response we need.
+type serverInfo struct {
+	State              string `json:"state"`
+	CommandLineOptions struct {
+		RestartEpoch *int `json:"restart_epoch"`
+	} `json:"command_line_options"`
+}
+
+// adminProbe check the readiness of the envoy listening on an admin address.
+type adminProbe struct {
+	address string
+	epoch   int
+	client  *http.Client
+}
+
+func newAdminProbe(address string, restartEpoch int) *adminProbe {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+
+	return &adminProbe{
+		address: strings.TrimRight(address, "/"),
+		epoch:   restartEpoch,
+		client:  &http.Client{Timeout: time.Second},
+	}
+}
+
+// ready tells whether the envoy answering is our epoch and it is live. Until
+// the new epoch takes the admin address over, the parent epoch answers, so
+// the restart epoch reported by /server_info must match ours.
+func (p *adminProbe) ready() (bool, error) {
+	resp, err := p.client.Get(p.address + "/ready")
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	// envoy older than 1.10 has no /ready, /server_info tells the state
+	readyEndpoint := resp.StatusCode != http.StatusNotFound
+	if readyEndpoint && resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("envoy admin /ready returned %s", resp.Status)
+	}
+
+	info, err := p.serverInfo()
+	if err != nil {
+		if readyEndpoint {
+			return true, nil
+		}
+		return false, err
+	}
+
+	if epoch := info.CommandLineOptions.RestartEpoch; epoch != nil && *epoch != p.epoch {
+		return false, fmt.Errorf("envoy admin is served by epoch %v", *epoch)
+	}
+
+	if !readyEndpoint && info.State != "LIVE" {
+		return false, fmt.Errorf("envoy state is %s", info.State)
+	}
+	return true, nil
+}
+
+func (p *adminProbe) serverInfo() (*serverInfo, error) {
+	resp, err := p.client.Get(p.address + "/server_info")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("envoy admin /server_info returned %s", resp.Status)
+	}
+
+	info := &serverInfo{}
+	if err := json.NewDecoder(resp.Body).Decode(info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// waitReady poll the envoy admin until the epoch is live, the process exits
+// or ctx is done.
+func waitReady(ctx context.Context, adminAddress string, restartEpoch int, exited <-chan struct{}) error {
+	probe := newAdminProbe(adminAddress, restartEpoch)
+
+	var lastErr error
+	for {
+		ready, err := probe.ready()
+		if ready {
+			return nil
+		}
+		lastErr = err
+
+		select {
+		case <-exited:
+			return fmt.Errorf("epoch %v exited before being ready", restartEpoch)
+		case <-ctx.Done():
+			return fmt.Errorf("epoch %v not ready: %s", restartEpoch, lastErr)
+		case <-time.After(readyPollInterval):
+		}
+	}
+}
+
+// readyCheck is the HealthCheck used when an admin address is given, the
+// epoch is healthy once envoy reports it live.
+func (r *Reenvoy) readyCheck(epoch *Epoch) func(ctx context.Context, c Child) error {
+	return func(ctx context.Context, c Child) error {
+		return waitReady(ctx, r.options().AdminAddress, epoch.Epoch, c.Exited())
+	}
+}
@@ -0,0 +1,74 @@
+package reenvoy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// adminStub serve /ready (unless readyStatus is 0) and /server_info for an
+// envoy at the given epoch and state.
+func adminStub(readyStatus int, epoch int, state string) *httptest.Server {
+	mux := http.NewServeMux()
+	if readyStatus != 0 {
+		mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(readyStatus)
+		})
+	}
+	mux.HandleFunc("/server_info", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"state": %q, "command_line_options": {"restart_epoch": %d}}`, state, epoch)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestWaitReady(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name        string
+		readyStatus int
+		epoch       int
+		state       string
+		ready       bool
+	}{
+		{"ready", http.StatusOK, 1, "LIVE", true},
+		{"not ready", http.StatusServiceUnavailable, 1, "PRE_INITIALIZING", false},
+		{"parent epoch", http.StatusOK, 0, "LIVE", false},
+		{"server_info live", 0, 1, "LIVE", true},
+		{"server_info initializing", 0, 1, "PRE_INITIALIZING", false},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := adminStub(c.readyStatus, c.epoch, c.state)
+			defer server.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+
+			err := waitReady(ctx, server.Listener.Addr().String(), 1, nil)
+			assert.Equal(t, c.ready, err == nil, "%v", err)
+		})
+	}
+}
+
+func TestWaitReady_exited(t *testing.T) {
+	t.Parallel()
+
+	server := adminStub(http.StatusServiceUnavailable, 1, "PRE_INITIALIZING")
+	defer server.Close()
+
+	exited := make(chan struct{})
+	close(exited)
+
+	err := waitReady(context.Background(), server.URL, 1, exited)
+	assert.Contains(t, err.Error(), "exited before being ready")
+}
@@ -1,10 +1,20 @@
 package reenvoy
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
 // PID process identification number in linux
@@ -13,143 +23,824 @@ type PID int
 // ReEnvoy will be hot restarted for config changes and binary updates
 type ReEnvoy interface {
 	Restart() error
-	StopAllChildren()
+	StopAllChildren(ctx context.Context) StopResult
 	ForceKillAllChildren()
 	IsExited() bool
+
+	// Status describe the newest epoch, or how the supervisor exits once
+	// every child is gone.
+	Status() Status
+
+	// RestartResults return the channel where the outcome of every restart
+	// requested through SIGHUP is reported.
+	RestartResults() <-chan RestartResult
+
+	// Wait blocks until no child is left after a teardown and return the
+	// status the supervisor should exit with.
+	Wait() int
+
+	// ReopenLogs send SIGUSR1 to every live child so Envoy reopen its access
+	// logs.
+	ReopenLogs() error
+
+	// Children list every live child, oldest epoch first.
+	Children() []Epoch
+
+	// Epoch return the restart epoch of the newest live child, -1 when no
+	// child is running.
+	Epoch() int
+
+	// ValidateConfig check the config with envoy --mode validate.
+	ValidateConfig() error
+
+	// Events subscribe to the lifecycle events until unsubscribe is called
+	// or ShutdownComplete.
+	Events() (events <-chan Event, unsubscribe func())
+
+	// MetricsHandler serve the supervisor metrics in the prometheus text
+	// format.
+	MetricsHandler() http.Handler
+
+	// ControlHandler serve the HTTP control API.
+	ControlHandler() http.Handler
+
+	// CrashLooping tells whether restarts are refused because too many of
+	// them failed recently.
+	CrashLooping() bool
+
+	// Upgrade hot restart to a new envoy binary, UpgradeImage to a new
+	// docker image.
+	Upgrade(binaryPath string) error
+	UpgradeImage(image string) error
+
+	// Logs return the last output of an epoch when CaptureLogs is set,
+	// DrainListeners ask envoy to drain its listeners through the admin.
+	Logs(epoch int) ([]byte, error)
+	DrainListeners(inboundOnly bool) error
 }
 
+// ChildErrors collect the errors returned by the children, by pid.
+type ChildErrors map[PID]error
+
+func (e ChildErrors) Error() string {
+	pids := make([]int, 0, len(e))
+	for pid := range e {
+		pids = append(pids, int(pid))
+	}
+	sort.Ints(pids)
+
+	msgs := make([]string, 0, len(pids))
+	for _, pid := range pids {
+		msgs = append(msgs, fmt.Sprintf("PID=%v: %s", pid, e[PID(pid)]))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// RestartResult is the outcome of a restart requested through SIGHUP.
+type RestartResult struct {
+	// Epoch is the restart epoch the new process was started with.
+	Epoch int
+	// PID is the pid of the new process, zero when the restart failed.
+	PID PID
+	// Requests is the number of restart requests collapsed into this restart.
+	Requests int
+	// RolledBack is true when the new epoch did not become healthy and the
+	// previous config was restarted instead, Reason tells why.
+	RolledBack bool
+	Reason     string
+	// Err is not nil when the restart failed, including when it rolled back.
+	Err error
+}
+
+// restartResultsBuffer is the number of results kept for slow readers, older
+// results are dropped once the buffer is full.
+const restartResultsBuffer = 16
+
 //Start start new process with default value
 func Start(opt SpawnOptions) (ReEnvoy, error) {
-	opt = defaultOptions(opt)
+	r := newReenvoy(opt)
+	if err := r.start(); err != nil {
+		r.abortStart()
+		return nil, err
+	}
+
+	r.handleSignals()
+	return r, nil
+}
 
-	r := &Reenvoy{
-		Options: opt,
+// start prepare the run dir, serve the metrics and control API and spawn the
+// first epoch.
+func (r *Reenvoy) start() error {
+	if r.options().RunDir != "" {
+		if err := r.prepareRunDir(); err != nil {
+			return err
+		}
 	}
 
-	if err := r.spawn(opt); err != nil {
-		return nil, err
+	if r.options().MetricsAddress != "" {
+		if err := r.serveMetrics(); err != nil {
+			return err
+		}
 	}
 
-	sigterm := make(chan os.Signal, 1)
-	sighub := make(chan os.Signal, 1)
+	if r.options().ControlAddress != "" {
+		if err := r.serveControl(); err != nil {
+			return err
+		}
+	}
 
-	// register our signal to receive notification
-	signal.Notify(sigterm, syscall.SIGINT, syscall.SIGTERM)
-	signal.Notify(sighub, syscall.SIGHUP)
+	if err := r.restoreState(); err != nil {
+		return err
+	}
 
-	go r.Sigterm(sigterm)
-	go r.Sighup(sighub)
+	version := knownHotRestartVersion(r.options())
+	epoch, err := r.spawn(r.options(), StateRunning, version)
+	if err != nil {
+		return err
+	}
 
-	return r, nil
+	if r.options().AdminAddress != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), r.options().ReadyTimeout)
+		defer cancel()
+
+		if err := r.readyCheck(epoch)(ctx, epoch.Child); err != nil {
+			r.epochs.remove(epoch.PID)
+			epoch.Child.Kill()
+			return err
+		}
+	}
+	r.takeConfigSnapshot()
+	r.emit(epochEvent(EpochReady, epoch))
+	return nil
+}
+
+// abortStart release what a failed start took before returning, so Start
+// can be tried again in the same process: the listeners are closed right away
+// rather than once done is closed, the pid file and socket are removed.
+func (r *Reenvoy) abortStart() {
+	for _, ln := range r.listeners {
+		ln.Close()
+	}
+
+	r.doneOnce.Do(func() {
+		if r.options().RunDir != "" {
+			r.cleanRunDir()
+		}
+		if opt := r.options(); opt.usesIPCHolder() {
+			opt.Docker.removeIPCHolder()
+		}
+		close(r.done)
+	})
 }
 
 //New return intance of ReEnvoy and default value without run a process
 func New(opt SpawnOptions) ReEnvoy {
+	r := newReenvoy(opt)
+
+	if r.options().RunDir != "" {
+		if err := r.prepareRunDir(); err != nil {
+			log.Println("[ERR]", err)
+		}
+	}
+
+	if r.options().MetricsAddress != "" {
+		if err := r.serveMetrics(); err != nil {
+			log.Println("[ERR]", err)
+		}
+	}
+
+	if r.options().ControlAddress != "" {
+		if err := r.serveControl(); err != nil {
+			log.Println("[ERR]", err)
+		}
+	}
+	r.handleSignals()
+	return r
+}
+
+func newReenvoy(opt SpawnOptions) *Reenvoy {
 	opt = defaultOptions(opt)
-	r := &Reenvoy{
-		Options: opt,
+	return &Reenvoy{
+		Options:   opt,
+		throttle:  restartThrottle{policy: opt.RestartPolicy},
+		metrics:   newMetrics(),
+		restartCh: make(chan struct{}, 1),
+		resultCh:  make(chan RestartResult, restartResultsBuffer),
+		exitCh:    make(chan childExit, 1),
+		done:      make(chan struct{}),
 	}
+}
 
+// handleSignals register our signal to receive notification and start the
+// long-lived loops handling them.
+func (r *Reenvoy) handleSignals() {
 	sigterm := make(chan os.Signal, 1)
 	sighub := make(chan os.Signal, 1)
+	sigusr1 := make(chan os.Signal, 1)
 
-	// register our signal to receive notification
 	signal.Notify(sigterm, syscall.SIGINT, syscall.SIGTERM)
 	signal.Notify(sighub, syscall.SIGHUP)
+	signal.Notify(sigusr1, syscall.SIGUSR1)
 
+	go r.restartLoop()
+	go r.Sigchild()
+	if r.options().WatchConfig {
+		go r.watchConfig()
+	}
 	go r.Sigterm(sigterm)
 	go r.Sighup(sighub)
-
-	return r
+	go r.Sigusr1(sigusr1)
 }
 
 type Reenvoy struct {
-	currentProcess Child
-	parentProcess  Child
-	Options        SpawnOptions
-	restartEpoch   int
+	// mu serializes restarts, a restart requested by SIGHUP may run at the
+	// same time as one requested through Restart.
+	mu sync.Mutex
+
+	// Options is replaced by restarts holding mu, read it through options
+	// anywhere else.
+	Options SpawnOptions
+	optLock sync.RWMutex
+	// restartEpoch is the epoch given to the next spawned child.
+	restartEpoch int
+	// config is the config the newest healthy epoch was started with,
+	// configHash its hash readable without holding mu.
+	config     configSnapshot
+	configHash atomic.Value
+	// throttle applies Options.RestartPolicy.
+	throttle restartThrottle
+	// events fan the lifecycle events out to the Events subscribers, metrics
+	// are collected from them.
+	events  eventBroker
+	metrics *metrics
+	// logs keep the last output of every epoch, for Logs.
+	logs epochLogs
+
+	// restartCh holds at most one pending restart request, pendingRestarts
+	// counts how many requests were collapsed into it.
+	restartCh       chan struct{}
+	pendingRestarts int32
+	resultCh        chan RestartResult
+
+	// epochs holds every live child, whatever its epoch. exitCh receive the
+	// children that went away.
+	epochs epochRegistry
+	exitCh chan childExit
+
+	// stopping is set once the children are torn down, their exit is no
+	// longer unexpected. done is closed when the last child is gone and
+	// exitCode is the status the supervisor should exit with. lastErr and
+	// lastExit are the last failed restart and the last child gone, for
+	// Status.
+	exitLock sync.Mutex
+	stopping bool
+	exitCode int
+	lastErr  error
+	lastExit childExit
+	done     chan struct{}
+	doneOnce sync.Once
+
+	// listeners are the metrics and control listeners, closed by abortStart.
+	listeners []net.Listener
 }
 
+// childExit describe a child process that went away, epoch is only known
+// once reaped.
+type childExit struct {
+	pid    PID
+	epoch  int
+	code   int
+	signal syscall.Signal
+}
+
+// IsExited tells whether envoy is gone, either every child went away for
+// good or the newest one has exited.
 func (r *Reenvoy) IsExited() bool {
-	if r.currentProcess == nil {
-		return false
+	state := r.Status().State
+	return state == StateExited || state == StateFailed
+}
+
+// spawn a new child process at the next restart epoch and keeps track of its
+// PID and the hot restart version it was started with. The epoch is only
+// consumed when the process started.
+func (r *Reenvoy) spawn(opt SpawnOptions, state ChildState, version string) (*Epoch, error) {
+	// a restart running while the children are torn down must not outlive
+	// them.
+	r.exitLock.Lock()
+	stopping := r.stopping
+	r.exitLock.Unlock()
+	if stopping {
+		return nil, errors.New("children are stopping, not spawning a new epoch")
 	}
 
-	state := r.currentProcess.ProcessState()
-	if state == nil {
-		return true
+	opt.RestartEpoch = r.restartEpoch
+
+	if opt.DockerContainer && opt.Command == "" {
+		if r.epochs.current() != nil && !opt.Docker.networkShared() {
+			log.Printf("[WARN] network %s is not shared by the epochs, envoy can not hot restart across it\n", opt.Docker.Network)
+		}
+		if opt.usesIPCHolder() {
+			if err := opt.Docker.startIPCHolder(); err != nil {
+				return nil, err
+			}
+		}
+		opt.Docker = opt.Docker.shareNamespaces()
 	}
-	return state.Exited()
-}
 
-// spawn a new child process and keeps track of its PID.
-func (r *Reenvoy) spawn(opt SpawnOptions) error {
-	process, err := SpawnProcess(opt, r.restartEpoch)
+	if opt.CaptureLogs {
+		logs := r.logs.add(opt.RestartEpoch)
+		opt.Stdout = teeWriter(opt.Stdout, logs)
+		opt.StdErr = teeWriter(opt.StdErr, logs)
+	}
+
+	process, err := startProcess(opt)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	log.Printf("[INFO] spawn new process with pid %v restart epoc \n", process.GetPID(), r.restartEpoch)
-	r.parentProcess = r.currentProcess
-	r.currentProcess = process
+	epoch := &Epoch{
+		Child:     process,
+		PID:       process.GetPID(),
+		Epoch:     r.restartEpoch,
+		StartedAt: time.Now(),
+		State:     state,
 
-	return nil
+		HotRestartVersion: version,
+	}
+	r.restartEpoch++
+
+	log.Printf("[INFO] spawn new process with pid %v restart epoch %v\n", epoch.PID, epoch.Epoch)
+	r.epochs.add(epoch)
+	r.saveState()
+	r.emit(epochEvent(EpochSpawned, epoch))
+
+	go r.watch(epoch.PID, process)
+
+	return epoch, nil
+}
+
+// watch report the child to Sigchild once it has exited.
+func (r *Reenvoy) watch(pid PID, c Child) {
+	<-c.Exited()
+	code, sig := c.ExitStatus()
+	r.exitCh <- childExit{pid: pid, code: code, signal: sig}
 }
 
 func (r *Reenvoy) Restart() error {
-	if err := r.spawn(r.Options); err != nil {
-		return err
+	return r.restart().Err
+}
+
+func (r *Reenvoy) restart() RestartResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.restartWith(r.options())
+}
+
+// restartWith restart to opt unless RestartPolicy refuses it, every failed
+// restart counts against the policy. The caller holds mu.
+func (r *Reenvoy) restartWith(opt SpawnOptions) RestartResult {
+	if err := r.throttle.allow(time.Now()); err != nil {
+		log.Println("[WARN] restart refused:", err)
+		r.setLastErr(ErrRestartThrottled)
+		r.metrics.restarted(RestartResult{Err: ErrRestartThrottled})
+		r.emit(Event{Type: RestartFailed, Time: time.Now(), Epoch: r.restartEpoch, Err: ErrRestartThrottled})
+		return RestartResult{Epoch: r.restartEpoch, Err: ErrRestartThrottled}
 	}
-	r.restartEpoch++
 
-	return nil
+	r.emit(Event{Type: RestartRequested, Time: time.Now(), Epoch: r.restartEpoch})
+	res := r.hotRestart(opt)
+	failed := restartFailed(res)
+	r.throttle.record(time.Now(), failed)
+	r.metrics.restarted(res)
+	if failed {
+		r.setLastErr(res.Err)
+		r.emit(Event{Type: RestartFailed, Time: time.Now(), PID: res.PID, Epoch: res.Epoch, Err: res.Err})
+	}
+	return res
+}
+
+// restartFailed tells whether res is a failed restart, a cold restart carries
+// a HotRestartVersionError but succeeded.
+func restartFailed(res RestartResult) bool {
+	switch restartOutcome(res) {
+	case "success", "cold_restart":
+		return false
+	}
+	return true
+}
+
+// hotRestart start a new epoch with opt, opt becomes our options once the
+// epoch is healthy. A failed epoch is rolled back to the current options.
+func (r *Reenvoy) hotRestart(opt SpawnOptions) RestartResult {
+	res := RestartResult{Epoch: r.restartEpoch}
+
+	// a config refused by envoy would make the new epoch exit right away,
+	// keep the running one instead.
+	if err := r.validate(opt); err != nil {
+		res.Err = err
+		return res
+	}
+
+	version, err := r.checkHotRestart(opt)
+	if mismatch, ok := err.(*HotRestartVersionError); ok && mismatch.Policy == HotRestartCold {
+		log.Printf("[WARN] hot restart version changed from %s to %s, stopping envoy to start it again\n", mismatch.Current, mismatch.New)
+		epoch, err := r.coldRestart(opt, version)
+		if err == nil {
+			res.Epoch = epoch.Epoch
+			res.PID = epoch.PID
+		}
+		mismatch.Err = err
+		res.Err = mismatch
+		return res
+	}
+	if err != nil {
+		res.Err = err
+		return res
+	}
+
+	parent := r.epochs.current()
+	epoch, err := r.spawn(opt, StateStarting, version)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+
+	if err := r.waitHealthy(epoch); err != nil {
+		res = r.rollback(epoch, err)
+		if !res.RolledBack {
+			// the failed epoch never took over, its parent keeps serving
+			if parent != nil {
+				r.epochs.setState(parent.PID, StateRunning)
+			}
+			if r.epochs.len() > 0 {
+				return res
+			}
+
+			r.exitLock.Lock()
+			if r.exitCode == ExitCodeOK {
+				r.exitCode = 1
+			}
+			r.exitLock.Unlock()
+			r.finishIfEmpty()
+		}
+		return res
+	}
+
+	r.setOptions(opt)
+	r.markReady(epoch, parent)
+	r.takeConfigSnapshot()
+
+	res.Epoch = epoch.Epoch
+	res.PID = epoch.PID
+	return res
+}
+
+// RestartResults return the channel where the outcome of every restart
+// requested through SIGHUP is reported.
+func (r *Reenvoy) RestartResults() <-chan RestartResult {
+	return r.resultCh
+}
+
+// requestRestart queue a restart without waiting for it. Requests arriving
+// while a restart is already pending are collapsed into that one.
+func (r *Reenvoy) requestRestart() {
+	atomic.AddInt32(&r.pendingRestarts, 1)
+
+	select {
+	case r.restartCh <- struct{}{}:
+	default:
+		log.Println("[INFO] restart already pending, collapsing request")
+	}
+}
+
+// restartLoop run the queued restarts one at a time and report their results.
+func (r *Reenvoy) restartLoop() {
+	for range r.restartCh {
+		requests := atomic.SwapInt32(&r.pendingRestarts, 0)
+
+		res := r.restart()
+		res.Requests = int(requests)
+		if restartFailed(res) {
+			log.Println("[ERR] restart failed:", res.Err)
+		}
+
+		r.publishResult(res)
+	}
+}
+
+// publishResult send res to RestartResults, dropping the oldest result when
+// nobody is reading.
+func (r *Reenvoy) publishResult(res RestartResult) {
+	for {
+		select {
+		case r.resultCh <- res:
+			return
+		default:
+		}
+
+		select {
+		case old := <-r.resultCh:
+			log.Printf("[WARN] dropping unread restart result for epoch %v\n", old.Epoch)
+		default:
+		}
+	}
 }
 
 // Sigterm handler for stop all the children process
 func (r *Reenvoy) Sigterm(signal chan os.Signal) {
 	sig := <-signal
 	log.Println("[INFO] recieve signal", sig)
-	r.StopAllChildren()
+
+	res := r.StopAllChildren(context.Background())
+	if res.Clean() {
+		log.Println("[INFO] all children exited cleanly")
+	}
 }
 
 // Sighup Handler when receive signal SIGUP.
-// This signal is used to cause the restarter to fork and exec a new child.
+// This signal is used to cause the restarter to fork and exec a new child,
+// every signal received queue a restart.
 func (r *Reenvoy) Sighup(signal chan os.Signal) {
-	sig := <-signal
-	log.Println("[INFO] recieve signal", sig)
+	for sig := range signal {
+		log.Println("[INFO] recieve signal", sig)
+		r.requestRestart()
+	}
 }
 
+// Sigchild reap the children that went away.
+// The exec package already waits for every child, so instead of racing it with
+// waitpid on SIGCHLD each child is reported here by its watch goroutine.
+// A non-zero exit or a death by signal is treated as fatal, every other child
+// is force killed so whoever started us can notice.
 func (r *Reenvoy) Sigchild() {
-	log.Println("[INFO] recieve signal", syscall.SIGCHLD)
+	for exit := range r.exitCh {
+		log.Println("[INFO] recieve signal", syscall.SIGCHLD, "for pid", exit.pid)
+		r.reap(exit)
+	}
+}
+
+func (r *Reenvoy) reap(exit childExit) {
+	removed := r.epochs.remove(exit.pid)
+	if removed == nil {
+		// already forgotten by a rollback
+		return
+	}
+	r.saveState()
+
+	exit.epoch = removed.Epoch
+	r.exitLock.Lock()
+	r.lastExit = exit
+	r.exitLock.Unlock()
+
+	// a child dying during startup is rolled back by the restart
+	if removed.State == StateStarting {
+		log.Printf("[WARN] PID=%v epoch %v went away during startup\n", exit.pid, removed.Epoch)
+		return
+	}
+
+	r.exitLock.Lock()
+	stopping := r.stopping
+	r.exitLock.Unlock()
+
+	status := ExitCodeOK
+	switch {
+	case exit.signal != 0:
+		log.Printf("[INFO] PID=%v was killed with signal=%v\n", exit.pid, exit.signal)
+		status = 128 + int(exit.signal)
+	case exit.code != ExitCodeOK:
+		log.Printf("[INFO] PID=%v exited with code=%v\n", exit.pid, exit.code)
+		status = exit.code
+	default:
+		log.Printf("[INFO] PID=%v exited with code=%v\n", exit.pid, exit.code)
+	}
+
+	event := epochEvent(ParentExited, removed)
+	event.ExitCode, event.Signal = exit.code, exit.signal
+	if status != ExitCodeOK && !stopping {
+		event.Type = ChildCrashed
+		event.Err = fmt.Errorf("PID=%v epoch %v exited with code=%v signal=%v", exit.pid, removed.Epoch, exit.code, exit.signal)
+	}
+	if event.Type == ChildCrashed || removed.State == StateDraining {
+		r.emit(event)
+	}
+
+	if !stopping && r.shouldRespawn(removed, status) {
+		go r.respawn(status)
+		return
+	}
+
+	if status != ExitCodeOK && !stopping {
+		log.Println("[ERR] Due to abnormal exit, force killing all child processes and exiting")
+		r.exitLock.Lock()
+		r.exitCode = status
+		r.exitLock.Unlock()
+		r.ForceKillAllChildren()
+	}
+
+	r.finishIfEmpty()
 }
 
-func (r *Reenvoy) Sigusr1() {
-	log.Println("[INFO] recieve signal", syscall.SIGUSR1)
+// finishIfEmpty release Wait once our last child died, we have no purpose.
+func (r *Reenvoy) finishIfEmpty() {
+	if r.epochs.len() == 0 {
+		r.doneOnce.Do(func() {
+			log.Println("[INFO] exiting due to lack of child processes")
+			if r.options().RunDir != "" {
+				r.cleanRunDir()
+			}
+			if opt := r.options(); opt.usesIPCHolder() {
+				opt.Docker.removeIPCHolder()
+			}
+			close(r.done)
+
+			r.exitLock.Lock()
+			event := Event{Type: ShutdownComplete, Time: time.Now(), Epoch: -1, ExitCode: r.exitCode}
+			r.exitLock.Unlock()
+			r.emit(event)
+			r.events.close()
+			if doneCh := r.options().DoneCh; doneCh != nil {
+				close(doneCh)
+			}
+		})
+	}
 }
 
-// StopAllChildren stop iterate through all known child processes, send a TERM signal to each of them.
-func (r *Reenvoy) StopAllChildren() {
-	if r.currentProcess != nil {
-		log.Println("[INFO] Stopped current process with pid", r.currentProcess.GetPID())
-		r.currentProcess.Stop()
+// Wait blocks until no child is left after a teardown and return the status
+// the supervisor should exit with: zero when every child exited cleanly,
+// otherwise the exit code of the failed child or 128+signal when it was
+// killed by a signal.
+func (r *Reenvoy) Wait() int {
+	<-r.done
+
+	r.exitLock.Lock()
+	defer r.exitLock.Unlock()
+	return r.exitCode
+}
+
+// Epoch return the restart epoch of the newest live child, -1 when no child
+// is running.
+func (r *Reenvoy) Epoch() int {
+	current := r.epochs.current()
+	if current == nil {
+		return -1
 	}
+	return current.Epoch
+}
+
+// options return a copy of Options, safe to use while a restart replace them.
+func (r *Reenvoy) options() SpawnOptions {
+	r.optLock.RLock()
+	defer r.optLock.RUnlock()
+	return r.Options
+}
+
+// setOptions replace Options, the caller holds mu.
+func (r *Reenvoy) setOptions(opt SpawnOptions) {
+	r.optLock.Lock()
+	r.Options = opt
+	r.optLock.Unlock()
+}
+
+// Children list every live child with its epoch number, start time and state,
+// oldest epoch first.
+func (r *Reenvoy) Children() []Epoch {
+	return r.epochs.list()
+}
+
+// Sigusr1 handler propagate every SIGUSR1 to all of the child processes.
+func (r *Reenvoy) Sigusr1(signal chan os.Signal) {
+	for sig := range signal {
+		log.Println("[INFO] recieve signal", sig)
+		if err := r.ReopenLogs(); err != nil {
+			log.Println("[ERR] reopen logs:", err)
+		}
+	}
+}
 
-	if r.parentProcess != nil {
-		log.Println("[INFO] Stopped parent process with pid", r.parentProcess.GetPID())
-		r.parentProcess.Stop()
+// ReopenLogs send SIGUSR1 to every live child, current and parent epochs, so
+// they reopen their access logs. The returned error is a ChildErrors holding
+// every pid that could not be signaled.
+func (r *Reenvoy) ReopenLogs() error {
+	errs := ChildErrors{}
+	for _, epoch := range r.epochs.list() {
+		log.Printf("[INFO] sending SIGUSR1 to PID=%v\n", epoch.PID)
+		if err := epoch.Child.Signal(syscall.SIGUSR1); err != nil {
+			log.Printf("[WARN] error in SIGUSR1 to PID=%v continuing\n", epoch.PID)
+			errs[epoch.PID] = err
+		}
 	}
 
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
 }
 
-// ForceKillAllChildren force kill current & parent process.
-func (r *Reenvoy) ForceKillAllChildren() {
-	if r.currentProcess != nil {
-		log.Println("[INFO] Kill current process with pid", r.currentProcess.GetPID())
-		r.currentProcess.Kill()
+// StopResult tells how the children went away during StopAllChildren.
+type StopResult struct {
+	// Exited lists the pids that exited after TERM before the deadline,
+	// whatever their status. ExitCodes holds their status, 128+signal when
+	// killed by a signal.
+	Exited    []PID
+	ExitCodes map[PID]int
+	// Killed lists the pids still running at the deadline, they have been
+	// force killed.
+	Killed []PID
+	// Errors holds the pids that could not be sent TERM.
+	Errors ChildErrors
+}
+
+// Clean is true when every child exited with a zero status before the
+// deadline.
+func (s StopResult) Clean() bool {
+	for _, code := range s.ExitCodes {
+		if code != ExitCodeOK {
+			return false
+		}
+	}
+	return len(s.Killed) == 0
+}
+
+// StopAllChildren iterate through all known child processes, send a TERM
+// signal to each of them at once, and then wait until the ctx deadline for
+// them to exit gracefully. When ctx has no deadline KillTimeout is used. Every
+// child still running at the deadline is force killed.
+func (r *Reenvoy) StopAllChildren(ctx context.Context) StopResult {
+	r.exitLock.Lock()
+	r.stopping = true
+	r.exitLock.Unlock()
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.options().KillTimeout)
+		defer cancel()
+	}
+
+	res := stopEpochs(ctx, r.epochs.list())
+	if !res.Clean() {
+		r.exitLock.Lock()
+		if r.exitCode == ExitCodeOK {
+			r.exitCode = 1
+		}
+		r.exitLock.Unlock()
+	}
+
+	r.finishIfEmpty()
+	return res
+}
+
+// stopEpochs send TERM to every epoch at once and force kill the ones still
+// running when ctx is done.
+func stopEpochs(ctx context.Context, epochs []Epoch) StopResult {
+	res := StopResult{ExitCodes: map[PID]int{}, Errors: ChildErrors{}}
+	for _, epoch := range epochs {
+		log.Printf("[INFO] sending TERM to PID=%v\n", epoch.PID)
+		if err := epoch.Child.Signal(syscall.SIGTERM); err != nil {
+			log.Printf("[WARN] error sending TERM to PID=%v continuing\n", epoch.PID)
+			res.Errors[epoch.PID] = err
+		}
 	}
 
-	if r.parentProcess != nil {
-		log.Println("[INFO] Kill parent process with pid", r.parentProcess.GetPID())
-		r.parentProcess.Kill()
+	// every child share the same deadline, waiting for them one after the
+	// other does not make the shutdown longer.
+	for _, epoch := range epochs {
+		select {
+		case <-epoch.Child.Exited():
+		case <-ctx.Done():
+		}
+
+		// the deadline may have passed while waiting for another child, one
+		// already gone still exited on its own.
+		select {
+		case <-epoch.Child.Exited():
+			res.Exited = append(res.Exited, epoch.PID)
+			res.ExitCodes[epoch.PID] = shellStatus(epoch.Child.ExitStatus())
+		default:
+			log.Printf("[WARN] child PID=%v did not exit cleanly, killing\n", epoch.PID)
+			epoch.Child.Signal(syscall.SIGKILL)
+			res.Killed = append(res.Killed, epoch.PID)
+		}
 	}
+
+	// Stop makes sure the process is gone and keeps the child from being
+	// started again.
+	for _, epoch := range epochs {
+		epoch.Child.Stop()
+	}
+	return res
+}
+
+// ForceKillAllChildren force kill every known child process.
+func (r *Reenvoy) ForceKillAllChildren() {
+	r.exitLock.Lock()
+	r.stopping = true
+	r.exitLock.Unlock()
+
+	for _, epoch := range r.epochs.list() {
+		log.Printf("[INFO] force killing process with pid %v epoch %v\n", epoch.PID, epoch.Epoch)
+		epoch.Child.Kill()
+	}
+
+	r.finishIfEmpty()
 }
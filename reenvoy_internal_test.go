@@ -0,0 +1,120 @@
+package reenvoy
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loopReenvoy return a supervisor running a bash loop in place of envoy, an
+// epoch is healthy as soon as it started.
+func loopReenvoy() *Reenvoy {
+	return newReenvoy(SpawnOptions{
+		Command:     "bash",
+		Args:        []string{"-c", "trap 'exit 0' TERM; while true; do sleep 0.1; done"},
+		KillTimeout: 2 * time.Second,
+		HealthCheck: func(ctx context.Context, c Child) error { return nil },
+	})
+}
+
+func TestReenvoy_restartLoop(t *testing.T) {
+	r := loopReenvoy()
+	defer r.StopAllChildren(context.Background())
+
+	// requests arriving before the loop runs are collapsed into one restart
+	r.requestRestart()
+	r.requestRestart()
+	r.requestRestart()
+	go r.restartLoop()
+
+	select {
+	case res := <-r.RestartResults():
+		require.Nil(t, res.Err)
+		assert.Equal(t, 3, res.Requests)
+		assert.Equal(t, 0, res.Epoch)
+		assert.NotZero(t, res.PID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no restart result")
+	}
+
+	select {
+	case res := <-r.RestartResults():
+		t.Fatalf("collapsed requests restarted again: %+v", res)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	r.requestRestart()
+	select {
+	case res := <-r.RestartResults():
+		require.Nil(t, res.Err)
+		assert.Equal(t, 1, res.Requests)
+		assert.Equal(t, 1, res.Epoch)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no restart result")
+	}
+	assert.Len(t, r.Children(), 2)
+}
+
+func TestReenvoy_publishResult(t *testing.T) {
+	r := newReenvoy(SpawnOptions{})
+
+	// nobody reads, the oldest results are dropped
+	for epoch := 0; epoch < restartResultsBuffer+3; epoch++ {
+		r.publishResult(RestartResult{Epoch: epoch, Requests: 1})
+	}
+
+	require.Len(t, r.RestartResults(), restartResultsBuffer)
+	for epoch := 3; epoch < restartResultsBuffer+3; epoch++ {
+		assert.Equal(t, epoch, (<-r.RestartResults()).Epoch)
+	}
+}
+
+func TestReenvoy_ReopenLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reenvoy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	signaled := filepath.Join(dir, "signaled")
+	r := loopReenvoy()
+	r.Options.Args = []string{"-c", fmt.Sprintf("trap 'echo usr1 $$ >> %[1]s' USR1; trap 'exit 0' TERM; echo ready >> %[1]s; while true; do sleep 0.1; done", signaled)}
+	defer r.StopAllChildren(context.Background())
+
+	parent := r.restart()
+	require.Nil(t, parent.Err)
+	current := r.restart()
+	require.Nil(t, current.Err)
+
+	// the traps are set once both epochs are ready
+	require.Eventually(t, func() bool {
+		b, _ := ioutil.ReadFile(signaled)
+		return strings.Count(string(b), "ready") == 2
+	}, 2*time.Second, 20*time.Millisecond)
+
+	// a child already gone can not be signaled
+	gone, err := startProcess(SpawnOptions{Command: "true"})
+	require.Nil(t, err)
+	<-gone.Exited()
+	r.epochs.add(&Epoch{Child: gone, PID: gone.GetPID(), Epoch: 2, State: StateRunning})
+	defer r.epochs.remove(gone.GetPID())
+
+	err = r.ReopenLogs()
+	require.NotNil(t, err)
+	errs, ok := err.(ChildErrors)
+	require.True(t, ok, "%T", err)
+	assert.Len(t, errs, 1)
+	assert.Contains(t, errs, gone.GetPID())
+
+	assert.Eventually(t, func() bool {
+		b, _ := ioutil.ReadFile(signaled)
+		return strings.Contains(string(b), fmt.Sprintf("usr1 %d", parent.PID)) &&
+			strings.Contains(string(b), fmt.Sprintf("usr1 %d", current.PID))
+	}, 2*time.Second, 20*time.Millisecond, "every live epoch got SIGUSR1")
+}
@@ -1,15 +1,24 @@
 package reenvoy_test
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/evo3cx/reenvoy"
 )
 
+const fileWaitSleepDelay = 500 * time.Millisecond
+
 func TestReenvoy_Start(t *testing.T) {
 	opts := reenvoy.SpawnOptions{
 		Command:      "echo",
@@ -23,3 +32,412 @@ func TestReenvoy_Start(t *testing.T) {
 	require.Nil(t, err, "start reenvoy")
 	require.NotEmpty(t, proc.GetPID())
 }
+
+// loopOptions run a bash loop exiting cleanly on TERM in place of envoy.
+func loopOptions() reenvoy.SpawnOptions {
+	return reenvoy.SpawnOptions{
+		Command:     "bash",
+		Args:        []string{"-c", "trap 'echo epoch $RESTART_EPOCH; exit 0' TERM USR1; while true; do sleep 0.1; done"},
+		KillTimeout: 2 * time.Second,
+	}
+}
+
+func TestReenvoy_Restart(t *testing.T) {
+	re, err := reenvoy.Start(loopOptions())
+	require.Nil(t, err, "start reenvoy")
+	assert.Equal(t, 0, re.Epoch())
+
+	require.Nil(t, re.Restart())
+	assert.Equal(t, 1, re.Epoch())
+
+	children := re.Children()
+	require.Len(t, children, 2)
+	assert.Equal(t, 0, children[0].Epoch)
+	assert.Equal(t, reenvoy.StateDraining, children[0].State)
+	assert.Equal(t, 1, children[1].Epoch)
+	assert.Equal(t, reenvoy.StateRunning, children[1].State)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	res := re.StopAllChildren(ctx)
+	assert.True(t, res.Clean())
+	assert.Len(t, res.Exited, 2)
+	assert.Equal(t, 0, re.Wait())
+}
+
+func TestReenvoy_StopAllChildrenDeadline(t *testing.T) {
+	// the first epoch ignores TERM, the second one exits with 3
+	opts := loopOptions()
+	opts.Args = []string{"-c", `if [ "$RESTART_EPOCH" = 0 ]; then trap '' TERM; else trap 'exit 3' TERM; fi; while true; do sleep 0.1; done`}
+
+	re, err := reenvoy.Start(opts)
+	require.Nil(t, err, "start reenvoy")
+	require.Nil(t, re.Restart())
+
+	children := re.Children()
+	require.Len(t, children, 2)
+	stubborn, failing := children[0].PID, children[1].PID
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	res := re.StopAllChildren(ctx)
+	assert.WithinDuration(t, started.Add(500*time.Millisecond), time.Now(), time.Second, "killed at the deadline")
+
+	assert.False(t, res.Clean())
+	assert.Equal(t, []reenvoy.PID{stubborn}, res.Killed)
+	assert.Equal(t, []reenvoy.PID{failing}, res.Exited)
+	assert.Equal(t, map[reenvoy.PID]int{failing: 3}, res.ExitCodes)
+	assert.Empty(t, res.Errors)
+	assert.Equal(t, 1, re.Wait())
+	assert.Empty(t, re.Children())
+}
+
+func TestReenvoy_AbnormalExit(t *testing.T) {
+	opts := loopOptions()
+	opts.Args = []string{"-c", "sleep 0.2; exit 3"}
+
+	re, err := reenvoy.Start(opts)
+	require.Nil(t, err, "start reenvoy")
+
+	done := make(chan int)
+	go func() { done <- re.Wait() }()
+
+	select {
+	case code := <-done:
+		assert.Equal(t, 3, code)
+	case <-time.After(2 * time.Second):
+		t.Fatal("supervisor should be done")
+	}
+
+	status := re.Status()
+	assert.Equal(t, reenvoy.StateFailed, status.State)
+	assert.Equal(t, 3, status.ExitCode)
+	assert.Equal(t, 0, status.Epoch)
+	assert.True(t, re.IsExited())
+}
+
+// fakeDocker put a docker shim first in PATH. It accepts every config in
+// validate mode, otherwise it logs its arguments to the returned file and
+// runs until TERM. docker rm and run -d exit right away, inspect tells
+// whether a container was started with run -d and not removed since.
+func fakeDocker(t *testing.T) (logFile string, restore func()) {
+	dir, err := ioutil.TempDir("", "reenvoy")
+	require.Nil(t, err)
+
+	logFile = filepath.Join(dir, "docker.log")
+	shim := `#!/bin/bash
+case "$*" in *"--mode validate"*) exit 0;; *"--hot-restart-version"*) echo 11.104; exit 0;; esac
+echo "$@" >> ` + logFile + `
+case "$1 $2" in
+  "inspect "*) [ -f ` + dir + `/running ] && echo true; exit 0;;
+  "run -d") touch ` + dir + `/running; exit 0;;
+  "rm -f") rm -f ` + dir + `/running; exit 0;;
+  "rm "*) exit 0;;
+esac
+trap 'exit 0' TERM
+while true; do sleep 0.1; done
+`
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "docker"), []byte(shim), 0755))
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+
+	return logFile, func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestReenvoy_DockerHotRestart(t *testing.T) {
+	logFile, restore := fakeDocker(t)
+	defer restore()
+
+	re, err := reenvoy.Start(reenvoy.SpawnOptions{
+		DockerContainer: true,
+		ConfigPath:      "/etc/envoy",
+		KillTimeout:     2 * time.Second,
+		Docker:          reenvoy.DockerOptions{ContainerName: "envoy-{{.Epoch}}", ShmSize: "1g"},
+	})
+	require.Nil(t, err, "start reenvoy")
+	require.Nil(t, re.Restart())
+
+	// the shims may log in any order, wait for both of them
+	runs := func() []string {
+		b, _ := ioutil.ReadFile(logFile)
+		runs := []string{}
+		for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+			if strings.HasPrefix(line, "run --name") {
+				runs = append(runs, line)
+			}
+		}
+		return runs
+	}
+	require.Eventually(t, func() bool { return len(runs()) == 2 }, 2*time.Second, 100*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	assert.True(t, re.StopAllChildren(ctx).Clean())
+	assert.Equal(t, 0, re.Wait())
+
+	epochs := runs()
+	sort.Strings(epochs)
+	assert.Contains(t, epochs[0], "run --name envoy-0")
+	assert.Contains(t, epochs[0], "--ipc container:reenvoy-ipc --pid host")
+	assert.NotContains(t, epochs[0], "--shm-size")
+	assert.Contains(t, epochs[0], "--restart-epoch 0")
+	assert.Contains(t, epochs[1], "run --name envoy-1")
+	assert.Contains(t, epochs[1], "--ipc container:reenvoy-ipc --pid host")
+	assert.Contains(t, epochs[1], "--restart-epoch 1")
+
+	// the holder of the ipc namespace is started once, outlives every epoch
+	// and goes away with the last one. An exited container left with the
+	// name of an epoch would make docker run fail.
+	b, err := ioutil.ReadFile(logFile)
+	require.Nil(t, err)
+	others := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		if !strings.HasPrefix(line, "run --name") {
+			others = append(others, line)
+		}
+	}
+	assert.Equal(t, []string{
+		"inspect -f {{.State.Running}} reenvoy-ipc",
+		"rm -f reenvoy-ipc",
+		"run -d --name reenvoy-ipc --ipc shareable --network none --shm-size 1g --entrypoint sleep envoyproxy/envoy:3f59fb5c0f6554f8b3f2e73ab4c1437a63d42668 infinity",
+		"rm envoy-0",
+		"inspect -f {{.State.Running}} reenvoy-ipc",
+		"rm envoy-1",
+		"rm -f reenvoy-ipc",
+	}, others)
+}
+
+// fakeEnvoy write an envoy shim and its config dir. The shim refuses configs
+// containing "bad" in validate mode. In serve mode it crashes on configs
+// containing "crash" and runs until TERM otherwise.
+func fakeEnvoy(t *testing.T) (opts reenvoy.SpawnOptions, restore func()) {
+	dir, err := ioutil.TempDir("", "reenvoy")
+	require.Nil(t, err)
+
+	// the trap comes first, a test may stop envoy as soon as it started
+	shim := `#!/bin/bash
+trap 'exit 0' TERM
+if [ "$1" = "--hot-restart-version" ]; then echo 11.104; exit 0; fi
+if [ "$2" = "validate" ]; then
+  if grep -q bad "$4"; then echo "error initializing configuration '$4': bad listener" >&2; exit 1; fi
+  exit 0
+fi
+for arg; do [ "$prev" = "-c" ] && config=$arg; prev=$arg; done
+if grep -q crash "$config"; then exit 2; fi
+while true; do sleep 0.1; done
+`
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "envoy"), []byte(shim), 0755))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "envoy.yaml"), []byte("good"), 0644))
+
+	opts = reenvoy.SpawnOptions{
+		BinaryPath:  filepath.Join(dir, "envoy"),
+		ConfigPath:  dir,
+		KillTimeout: 2 * time.Second,
+	}
+	return opts, func() { os.RemoveAll(dir) }
+}
+
+func TestReenvoy_RestartInvalidConfig(t *testing.T) {
+	opts, restore := fakeEnvoy(t)
+	defer restore()
+
+	re, err := reenvoy.Start(opts)
+	require.Nil(t, err, "start reenvoy")
+	require.Nil(t, re.ValidateConfig())
+
+	require.Nil(t, ioutil.WriteFile(filepath.Join(opts.ConfigPath, "envoy.yaml"), []byte("bad"), 0644))
+
+	err = re.Restart()
+	require.IsType(t, &reenvoy.ConfigValidationError{}, err)
+	assert.Contains(t, err.(*reenvoy.ConfigValidationError).Messages[0], "bad listener")
+
+	// the running epoch is left untouched
+	assert.Equal(t, 0, re.Epoch())
+	assert.Len(t, re.Children(), 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	assert.True(t, re.StopAllChildren(ctx).Clean())
+}
+
+func TestReenvoy_RestartRollback(t *testing.T) {
+	opts, restore := fakeEnvoy(t)
+	defer restore()
+
+	re, err := reenvoy.Start(opts)
+	require.Nil(t, err, "start reenvoy")
+
+	// let the first epoch read its config
+	time.Sleep(fileWaitSleepDelay)
+
+	config := filepath.Join(opts.ConfigPath, "envoy.yaml")
+	require.Nil(t, ioutil.WriteFile(config, []byte("crash"), 0644))
+
+	err = re.Restart()
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "rolled back to epoch 1")
+	assert.Contains(t, err.Error(), "exited during startup with code=2")
+	assert.NotContains(t, err.Error(), "signal")
+
+	// the previous config is restored and serving at the failed epoch, the
+	// one after its parent
+	b, err := ioutil.ReadFile(config)
+	require.Nil(t, err)
+	assert.Equal(t, "good", string(b))
+	assert.Equal(t, 1, re.Epoch())
+
+	children := re.Children()
+	require.Len(t, children, 2)
+	assert.Equal(t, 0, children[0].Epoch)
+	assert.Equal(t, reenvoy.StateRunning, children[1].State)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	assert.True(t, re.StopAllChildren(ctx).Clean())
+	assert.Equal(t, 0, re.Wait())
+}
+
+func TestReenvoy_RestartFailedParentRunning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reenvoy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ready := filepath.Join(dir, "ready")
+	opts := loopOptions()
+	// without ConfigPath there is no config to roll back to
+	opts.Args = []string{"-c", fmt.Sprintf("[ $RESTART_EPOCH = 1 ] && exit 2; trap 'exit 0' TERM; touch %s; while true; do sleep 0.1; done", ready)}
+
+	re, err := reenvoy.Start(opts)
+	require.Nil(t, err, "start reenvoy")
+
+	// the parent exits cleanly once its trap is set
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(ready)
+		return err == nil
+	}, 2*time.Second, 20*time.Millisecond)
+
+	err = re.Restart()
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "no previous config to roll back to")
+
+	// the parent is still the one serving
+	children := re.Children()
+	require.Len(t, children, 1)
+	assert.Equal(t, 0, children[0].Epoch)
+	assert.Equal(t, reenvoy.StateRunning, children[0].State)
+	assert.Equal(t, reenvoy.StateRunning, re.Status().State)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	assert.True(t, re.StopAllChildren(ctx).Clean())
+	assert.Equal(t, 0, re.Wait())
+}
+
+func TestReenvoy_RestartThrottled(t *testing.T) {
+	opts := loopOptions()
+	opts.RestartPolicy = reenvoy.RestartPolicy{MinInterval: time.Minute}
+
+	re, err := reenvoy.Start(opts)
+	require.Nil(t, err, "start reenvoy")
+
+	require.Nil(t, re.Restart())
+	assert.Equal(t, reenvoy.ErrRestartThrottled, re.Restart())
+
+	status := re.Status()
+	assert.Equal(t, reenvoy.StateRunning, status.State)
+	assert.Equal(t, 1, status.Epoch)
+	assert.Equal(t, reenvoy.ErrRestartThrottled, status.Err)
+	assert.Equal(t, 1, re.Epoch())
+	assert.False(t, re.CrashLooping())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	assert.True(t, re.StopAllChildren(ctx).Clean())
+	assert.Equal(t, 0, re.Wait())
+}
+
+func TestReenvoy_StatusDuringRestart(t *testing.T) {
+	re, err := reenvoy.Start(loopOptions())
+	require.Nil(t, err, "start reenvoy")
+
+	// /status and /healthz poll while restarts change the epoch states
+	done := make(chan struct{})
+	polled := make(chan []reenvoy.ChildState)
+	go func() {
+		var states []reenvoy.ChildState
+		for {
+			select {
+			case <-done:
+				polled <- states
+				return
+			default:
+			}
+			states = append(states, re.Status().State)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	require.Nil(t, re.Restart())
+	require.Nil(t, re.Restart())
+	close(done)
+
+	states := <-polled
+	require.NotEmpty(t, states)
+	for _, state := range states {
+		assert.Contains(t, []reenvoy.ChildState{reenvoy.StateStarting, reenvoy.StateRunning}, state)
+	}
+	assert.Equal(t, reenvoy.StateRunning, re.Status().State)
+	assert.Equal(t, 2, re.Status().Epoch)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	assert.True(t, re.StopAllChildren(ctx).Clean())
+	assert.Equal(t, 0, re.Wait())
+}
+
+func TestReenvoy_Events(t *testing.T) {
+	opts := loopOptions()
+	opts.ErrCh = make(chan error, 1)
+	opts.DoneCh = make(chan struct{})
+
+	re, err := reenvoy.Start(opts)
+	require.Nil(t, err, "start reenvoy")
+
+	events, unsubscribe := re.Events()
+	defer unsubscribe()
+
+	// a subscriber never reading does not block anything
+	_, _ = re.Events()
+
+	require.Nil(t, re.Restart())
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	assert.True(t, re.StopAllChildren(ctx).Clean())
+	assert.Equal(t, 0, re.Wait())
+
+	var got []string
+	for event := range events {
+		got = append(got, fmt.Sprintf("%s %v", event.Type, event.Epoch))
+	}
+	assert.Equal(t, []string{
+		"restart_requested 1",
+		"epoch_spawned 1",
+		"epoch_ready 1",
+		"parent_drain_started 0",
+		"parent_exited 0",
+		"shutdown_complete -1",
+	}, got)
+
+	select {
+	case <-opts.DoneCh:
+	default:
+		t.Error("DoneCh should be closed")
+	}
+}
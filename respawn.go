@@ -0,0 +1,142 @@
+package reenvoy
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	// respawnBackoff is the first wait before respawning when the
+	// RestartPolicy has no Backoff, doubled after every failed respawn up to
+	// respawnMaxBackoff.
+	respawnBackoff    = time.Second
+	respawnMaxBackoff = 30 * time.Second
+)
+
+// RespawnPolicy tells whether envoy is started again after the sole running
+// epoch went away unexpectedly.
+type RespawnPolicy int
+
+const (
+	// RespawnNever tear everything down like hot-restarter.py does, whoever
+	// started us should notice. This is the default.
+	RespawnNever RespawnPolicy = iota
+	// RespawnOnFailure respawn after a non-zero exit or a death by signal.
+	RespawnOnFailure
+	// RespawnAlways respawn whatever the exit status.
+	RespawnAlways
+)
+
+func (p RespawnPolicy) String() string {
+	switch p {
+	case RespawnNever:
+		return "never"
+	case RespawnOnFailure:
+		return "on-failure"
+	case RespawnAlways:
+		return "always"
+	}
+	return "unknown"
+}
+
+// shouldRespawn tells whether the exit of epoch with status is respawned. Only
+// the running epoch is, when no parent epoch is left draining.
+func (r *Reenvoy) shouldRespawn(epoch *Epoch, status int) bool {
+	if epoch.State != StateRunning || r.epochs.len() > 0 {
+		return false
+	}
+
+	switch r.options().Respawn {
+	case RespawnAlways:
+		return true
+	case RespawnOnFailure:
+		return status != ExitCodeOK
+	}
+	return false
+}
+
+// respawn start envoy again at the next epoch, backing off between failed
+// attempts. The exit counts as a failed restart for the RestartPolicy, once
+// crash-looping we give up and exit with status.
+func (r *Reenvoy) respawn(status int) {
+	r.throttle.record(time.Now(), true)
+
+	policy := r.options().RestartPolicy
+	wait, maxWait := policy.Backoff, policy.MaxBackoff
+	if wait <= 0 {
+		wait = respawnBackoff
+	}
+	if maxWait <= 0 {
+		maxWait = respawnMaxBackoff
+	}
+
+	for {
+		log.Printf("[INFO] respawning in %v\n", wait)
+		select {
+		case <-r.done:
+			return
+		case <-time.After(wait):
+		}
+
+		if r.CrashLooping() {
+			log.Println("[ERR] crash-looping, giving up respawning")
+			r.exitLock.Lock()
+			r.stopping = true
+			if r.exitCode = status; status == ExitCodeOK {
+				r.exitCode = 1
+			}
+			r.exitLock.Unlock()
+			r.finishIfEmpty()
+			return
+		}
+
+		epoch, err := r.respawnEpoch()
+		if err == nil {
+			log.Printf("[INFO] respawned PID=%v at epoch %v\n", epoch.PID, epoch.Epoch)
+			return
+		}
+		log.Println("[ERR] respawn failed:", err)
+		r.setLastErr(err)
+
+		r.exitLock.Lock()
+		stopping := r.stopping
+		r.exitLock.Unlock()
+		if stopping {
+			r.finishIfEmpty()
+			return
+		}
+
+		if wait *= 2; wait > maxWait {
+			wait = maxWait
+		}
+	}
+}
+
+// respawnEpoch start the next epoch with our options and wait for it to be
+// healthy. There is nothing to roll back to, a failed epoch is just killed.
+func (r *Reenvoy) respawnEpoch() (*Epoch, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.throttle.allow(time.Now()); err != nil {
+		return nil, err
+	}
+
+	opt := r.options()
+	version := knownHotRestartVersion(opt)
+	epoch, err := r.spawn(opt, StateStarting, version)
+	if err == nil {
+		err = r.waitHealthy(epoch)
+		if err != nil {
+			r.epochs.remove(epoch.PID)
+			epoch.Child.Kill()
+		}
+	}
+	r.throttle.record(time.Now(), err != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	r.markReady(epoch, nil)
+	return epoch, nil
+}
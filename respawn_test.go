@@ -0,0 +1,61 @@
+package reenvoy_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/evo3cx/reenvoy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReenvoy_Respawn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reenvoy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// crash the first time only
+	marker := filepath.Join(dir, "crashed")
+	opts := loopOptions()
+	opts.Args = []string{"-c", "if [ ! -f " + marker + " ]; then touch " + marker + "; sleep 0.2; exit 3; fi; trap 'exit 0' TERM; while true; do sleep 0.1; done"}
+	opts.Respawn = reenvoy.RespawnOnFailure
+	opts.RestartPolicy = reenvoy.RestartPolicy{Backoff: 100 * time.Millisecond}
+
+	re, err := reenvoy.Start(opts)
+	require.Nil(t, err, "start reenvoy")
+
+	assert.True(t, waitEpoch(re, 1, 3*time.Second))
+	assert.False(t, re.IsExited())
+
+	stopReenvoy(t, re)
+	assert.True(t, re.IsExited())
+}
+
+func TestReenvoy_RespawnCrashLooping(t *testing.T) {
+	opts := loopOptions()
+	opts.Args = []string{"-c", "sleep 0.2; exit 3"}
+	opts.Respawn = reenvoy.RespawnOnFailure
+	opts.RestartPolicy = reenvoy.RestartPolicy{
+		Backoff:       50 * time.Millisecond,
+		MaxFailures:   2,
+		FailureWindow: time.Minute,
+	}
+
+	re, err := reenvoy.Start(opts)
+	require.Nil(t, err, "start reenvoy")
+
+	done := make(chan int)
+	go func() { done <- re.Wait() }()
+
+	select {
+	case code := <-done:
+		assert.Equal(t, 3, code)
+		assert.True(t, re.CrashLooping())
+		assert.True(t, re.IsExited())
+	case <-time.After(5 * time.Second):
+		t.Fatal("supervisor should give up respawning")
+	}
+}
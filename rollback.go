@@ -0,0 +1,209 @@
+package reenvoy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// startupGracePeriod is how long a new epoch has to stay up to be considered
+// healthy when no HealthCheck is given.
+const startupGracePeriod = time.Second
+
+// configSnapshot holds the content of the config files by name.
+type configSnapshot map[string][]byte
+
+// snapshotConfig read every regular file directly under dir. Hidden files
+// are left out, they are our own temporary files or the ..data plumbing of a
+// kubernetes ConfigMap.
+func snapshotConfig(dir string) (configSnapshot, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := configSnapshot{}
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+
+		// os.Stat follows the symlinks of a kubernetes ConfigMap
+		info, err := os.Stat(filepath.Join(dir, file.Name()))
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		snapshot[file.Name()] = b
+	}
+	return snapshot, nil
+}
+
+// hash return the sha256 of the file names and contents.
+func (s configSnapshot) hash() string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s\x00%d\x00", name, len(s[name]))
+		h.Write(s[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// restore write the snapshot back to dir, every file is replaced atomically.
+func (s configSnapshot) restore(dir string) error {
+	for name, b := range s {
+		if err := writeFileAtomic(filepath.Join(dir, name), b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// takeConfigSnapshot keep the config the current epoch runs with, a failed
+// restart rolls back to it.
+func (r *Reenvoy) takeConfigSnapshot() {
+	path := r.options().ConfigPath
+	if path == "" {
+		return
+	}
+
+	snapshot, err := snapshotConfig(path)
+	if err != nil {
+		log.Println("[WARN] snapshot config:", err)
+		return
+	}
+	r.config = snapshot
+	r.configHash.Store(snapshot.hash())
+}
+
+// runningConfigHash return the hash of the config the newest healthy epoch
+// was started with.
+func (r *Reenvoy) runningConfigHash() string {
+	hash, _ := r.configHash.Load().(string)
+	return hash
+}
+
+// aliveCheck is the default HealthCheck, the child is healthy once it stayed
+// up for startupGracePeriod.
+func aliveCheck(ctx context.Context, c Child) error {
+	select {
+	case <-c.Exited():
+		return fmt.Errorf("process exited during startup")
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(startupGracePeriod):
+		return nil
+	}
+}
+
+// waitHealthy wait for the epoch to report healthy within HealthyTimeout, or
+// to be ready within ReadyTimeout when an admin address is given.
+func (r *Reenvoy) waitHealthy(epoch *Epoch) error {
+	opt := r.options()
+	timeout := opt.HealthyTimeout
+	check := opt.HealthCheck
+	if check == nil {
+		check = aliveCheck
+		if opt.AdminAddress != "" {
+			check = r.readyCheck(epoch)
+			timeout = opt.ReadyTimeout
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- check(ctx, epoch.Child)
+	}()
+
+	select {
+	case <-epoch.Child.Exited():
+		code, sig := epoch.Child.ExitStatus()
+		if sig != 0 {
+			return fmt.Errorf("epoch %v was killed during startup with signal=%v", epoch.Epoch, sig)
+		}
+		return fmt.Errorf("epoch %v exited during startup with code=%v", epoch.Epoch, code)
+	case err := <-errCh:
+		if err != nil {
+			return fmt.Errorf("epoch %v is not healthy: %s", epoch.Epoch, err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("epoch %v is not healthy after %v", epoch.Epoch, timeout)
+	}
+}
+
+// rollback kill the unhealthy epoch, restore the config of the previous one
+// and start an epoch from it again. The parent of the failed epoch may
+// already be draining, so a new epoch is needed to keep serving.
+func (r *Reenvoy) rollback(failed *Epoch, reason error) RestartResult {
+	log.Printf("[ERR] rolling back epoch %v: %s\n", failed.Epoch, reason)
+	r.epochs.remove(failed.PID)
+	failed.Child.Kill()
+
+	// envoy at epoch N hot restarts from the one at N-1, the number of the
+	// failed epoch is taken again once it is gone
+	select {
+	case <-failed.Child.Exited():
+	case <-time.After(r.options().KillTimeout):
+	}
+	r.restartEpoch = failed.Epoch
+
+	res := RestartResult{Epoch: failed.Epoch, Reason: reason.Error()}
+	if r.config == nil {
+		res.Err = fmt.Errorf("restart failed, no previous config to roll back to: %s", reason)
+		return res
+	}
+
+	if err := r.config.restore(r.options().ConfigPath); err != nil {
+		res.Err = fmt.Errorf("restart failed, restore previous config: %s", err)
+		return res
+	}
+
+	// the parent of the failed epoch runs the binary of our options
+	version := ""
+	parent := r.epochs.current()
+	if parent != nil {
+		version = parent.HotRestartVersion
+	}
+
+	epoch, err := r.spawn(r.options(), StateStarting, version)
+	if err == nil {
+		err = r.waitHealthy(epoch)
+		if err != nil {
+			r.epochs.remove(epoch.PID)
+			epoch.Child.Kill()
+		}
+	}
+	if err != nil {
+		res.Err = fmt.Errorf("restart failed, rollback failed: %s", err)
+		return res
+	}
+
+	r.markReady(epoch, parent)
+	res.RolledBack = true
+	res.Epoch = epoch.Epoch
+	res.PID = epoch.PID
+	res.Err = fmt.Errorf("restart rolled back to epoch %v: %s", epoch.Epoch, reason)
+	return res
+}
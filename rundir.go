@@ -0,0 +1,93 @@
+package reenvoy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PIDFileName is the file in SpawnOptions.RunDir holding the pid of the
+	// supervisor.
+	PIDFileName = "reenvoy.pid"
+	// ControlSocketName is the unix socket in SpawnOptions.RunDir the control
+	// API is served on, unless ControlAddress says otherwise.
+	ControlSocketName = "reenvoy.sock"
+)
+
+// DefaultRunDir return the run dir reenvoy and reenvoyctl use unless told
+// otherwise, $REENVOY_RUN_DIR. There is none when not set: no directory is
+// writable by every user, nor can one be shared by every supervisor.
+func DefaultRunDir() string {
+	return os.Getenv("REENVOY_RUN_DIR")
+}
+
+// ControlSocket return the control address of the supervisor using runDir.
+func ControlSocket(runDir string) string {
+	return "unix:" + filepath.Join(runDir, ControlSocketName)
+}
+
+// ReadPIDFile return the pid of the supervisor using runDir, an error when
+// there is none or it is gone.
+func ReadPIDFile(runDir string) (PID, error) {
+	path := filepath.Join(runDir, PIDFileName)
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, fmt.Errorf("reenvoy is not running, no %s", path)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("pid file %s: %s", path, err)
+	}
+	if !processAlive(PID(pid), "") {
+		return 0, fmt.Errorf("reenvoy is not running, stale pid file %s with PID=%v", path, pid)
+	}
+	return PID(pid), nil
+}
+
+// prepareRunDir create Options.RunDir and write our pid file there, another
+// live supervisor using the same directory is refused.
+func (r *Reenvoy) prepareRunDir() error {
+	dir := r.options().RunDir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("run dir: %s", err)
+	}
+
+	if pid, err := ReadPIDFile(dir); err == nil && int(pid) != os.Getpid() {
+		return fmt.Errorf("run dir %s is used by reenvoy PID=%v", dir, pid)
+	}
+
+	path := filepath.Join(dir, PIDFileName)
+	if err := writeFileAtomic(path, []byte(fmt.Sprintf("%d\n", os.Getpid()))); err != nil {
+		return fmt.Errorf("pid file: %s", err)
+	}
+	return nil
+}
+
+// cleanRunDir remove our pid file and control socket once every child is
+// gone, before Wait returns so they are gone when we exit.
+func (r *Reenvoy) cleanRunDir() {
+	opt := r.options()
+	dir := opt.RunDir
+	if pid, err := ReadPIDFile(dir); err != nil || int(pid) != os.Getpid() {
+		return
+	}
+
+	paths := []string{filepath.Join(dir, PIDFileName)}
+	if opt.ControlAddress == ControlSocket(dir) {
+		paths = append(paths, filepath.Join(dir, ControlSocketName))
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Println("[WARN] cleaning run dir:", err)
+		}
+	}
+}
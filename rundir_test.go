@@ -0,0 +1,141 @@
+package reenvoy_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evo3cx/reenvoy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadPIDFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reenvoy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = reenvoy.ReadPIDFile(dir)
+	assert.NotNil(t, err, "no pid file")
+
+	path := filepath.Join(dir, reenvoy.PIDFileName)
+	require.Nil(t, ioutil.WriteFile(path, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644))
+	pid, err := reenvoy.ReadPIDFile(dir)
+	require.Nil(t, err)
+	assert.Equal(t, reenvoy.PID(os.Getpid()), pid)
+
+	// far above any pid_max
+	require.Nil(t, ioutil.WriteFile(path, []byte("99999999\n"), 0644))
+	_, err = reenvoy.ReadPIDFile(dir)
+	assert.Contains(t, fmt.Sprint(err), "stale pid file")
+}
+
+func TestReenvoy_RunDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reenvoy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	runDir := filepath.Join(dir, "run")
+	opts := loopOptions()
+	opts.Args = []string{"-c", "echo epoch $RESTART_EPOCH; trap 'exit 0' TERM; while true; do sleep 0.1; done"}
+	opts.RunDir = runDir
+	opts.CaptureLogs = true
+
+	re, err := reenvoy.Start(opts)
+	require.Nil(t, err, "start reenvoy")
+
+	pid, err := reenvoy.ReadPIDFile(runDir)
+	require.Nil(t, err)
+	assert.Equal(t, reenvoy.PID(os.Getpid()), pid)
+
+	client := unixClient(filepath.Join(runDir, reenvoy.ControlSocketName))
+
+	var logs struct {
+		Epoch int    `json:"epoch"`
+		Logs  string `json:"logs"`
+	}
+	require.Eventually(t, func() bool {
+		resp, err := client.Get("http://reenvoy/logs?epoch=0")
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		return json.NewDecoder(resp.Body).Decode(&logs) == nil && strings.Contains(logs.Logs, "epoch 0")
+	}, 2*time.Second, 50*time.Millisecond)
+
+	resp, err := client.Get("http://reenvoy/logs?epoch=5")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = client.Post("http://reenvoy/drain", "", nil)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "no admin address to drain through")
+
+	resp, err = client.Post("http://reenvoy/stop", "", nil)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 0, re.Wait())
+
+	files, err := ioutil.ReadDir(runDir)
+	require.Nil(t, err)
+	assert.Empty(t, files, "pid file and socket removed")
+}
+
+func TestReenvoy_RunDirInUse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reenvoy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// our parent stands for another live supervisor
+	path := filepath.Join(dir, reenvoy.PIDFileName)
+	require.Nil(t, ioutil.WriteFile(path, []byte(fmt.Sprintf("%d\n", os.Getppid())), 0644))
+
+	opts := loopOptions()
+	opts.RunDir = dir
+	_, err = reenvoy.Start(opts)
+	assert.Contains(t, fmt.Sprint(err), "is used by reenvoy")
+}
+
+func TestReenvoy_StartFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reenvoy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	metrics := ln.Addr().String()
+	ln.Close()
+
+	opts := loopOptions()
+	opts.RunDir = dir
+	opts.MetricsAddress = metrics
+	opts.Command = filepath.Join(dir, "no-such-envoy")
+	_, err = reenvoy.Start(opts)
+	require.NotNil(t, err)
+
+	// nothing is left behind by the failed start
+	files, err := ioutil.ReadDir(dir)
+	require.Nil(t, err)
+	assert.Empty(t, files, "pid file and socket removed")
+	ln, err = net.Listen("tcp", metrics)
+	require.Nil(t, err, "metrics address released")
+	ln.Close()
+
+	// and starting again in the same process works
+	opts.Command = "bash"
+	re, err := reenvoy.Start(opts)
+	require.Nil(t, err, "start reenvoy again")
+	assert.Equal(t, 0, re.Epoch())
+	re.StopAllChildren(context.Background())
+	re.Wait()
+}
@@ -1,21 +1,41 @@
 package reenvoy
 
 import (
+	"context"
+	"fmt"
 	"io"
+	"os"
 	"time"
 )
 
-const (
-	envoyDockerImage = "envoyproxy/envoy:3f59fb5c0f6554f8b3f2e73ab4c1437a63d42668"
-)
-
 //SpawnOptions spawn child options
 type SpawnOptions struct {
 	// ErrCh and DoneCh are channels where errors and finish notifications occur.
+	// Failed restarts and crashed children are sent to ErrCh when it is
+	// ready to receive, DoneCh is closed once every child is gone for good.
 	ErrCh      chan error
 	DoneCh     chan struct{}
 	ConfigPath string
 
+	// Command is the name of the command to execute instead of envoy, any
+	// binary supporting hot restart can be supervised this way. Args are the
+	// list of arguments to pass when starting the command. The restart epoch
+	// is given to the command through the RESTART_EPOCH environment variable.
+	Command string
+	Args    []string
+
+	// RestartEpoch is the epoch the process is started with, Reenvoy set it
+	// for every process it spawns.
+	RestartEpoch int
+
+	// ReloadSignal is the signal to send to reload the process. This value may
+	// be nil.
+	ReloadSignal os.Signal
+
+	// KillSignal is the signal to send to gracefully kill the process. This
+	// value may be nil.
+	KillSignal os.Signal
+
 	// Env specifies the environment of the process.
 	// Each entry is of the form "key=value".
 	// If Env is nil, the new process uses the current process's
@@ -34,31 +54,150 @@ type SpawnOptions struct {
 
 	DockerContainer bool
 
+	// Docker configure the image and the container envoy runs in when
+	// DockerContainer is set.
+	Docker DockerOptions
+
 	Stdout io.Writer
 	StdErr io.Writer
 
+	// CaptureLogs keep the last output of every epoch for Logs. The output
+	// then goes through a pipe read by the supervisor rather than straight to
+	// Stdout and StdErr, a child adopted by the next supervisor is left with
+	// a broken pipe and may die of SIGPIPE on its next write.
+	CaptureLogs bool
+
 	// ParentShutdownTimes The time in second that Envoy will wait before shutting down the parent process during a hot restart.
 	// Readmore at https://www.envoyproxy.io/docs/envoy/v1.7.0/intro/arch_overview/hot_restart#arch-overview-hot-restart
 	ParentShutdownTimes time.Duration
 
 	//DrainTimes the time in second that Envoy will drain connection during restart
 	DrainTimes time.Duration
+
+	// BinaryPath is the envoy binary to run, envoy from $PATH when empty. It
+	// is not used in docker mode, the image brings its own envoy.
+	BinaryPath string
+
+	// Envoy holds the typed Envoy command line options.
+	Envoy EnvoyOptions
+
+	// SkipConfigValidation disable running envoy --mode validate before every
+	// restart.
+	SkipConfigValidation bool
+
+	// ExtraArgs are passed as is at the end of the envoy command line.
+	ExtraArgs []string
+
+	// AdminAddress is the envoy admin address, e.g. 127.0.0.1:9901. When set,
+	// a new epoch is only reported started once /ready (or the /server_info
+	// state for envoy without /ready) says it is live.
+	AdminAddress string
+
+	// ReadyTimeout is how long a new epoch has to become live on the admin
+	// address.
+	ReadyTimeout time.Duration
+
+	// HealthyTimeout is how long a new epoch started by a restart has to
+	// become healthy. When it does not, it is killed and the previous config
+	// is restored and restarted.
+	HealthyTimeout time.Duration
+
+	// HealthCheck report the new epoch healthy by returning nil before ctx is
+	// done. When nil the epoch is healthy once it is ready on AdminAddress, or
+	// once it stayed up for a second without an admin address.
+	HealthCheck func(ctx context.Context, c Child) error
+
+	// Respawn tells whether envoy is started again at the next epoch when the
+	// sole running epoch went away unexpectedly, RespawnNever by default.
+	// Respawns back off and count against RestartPolicy.
+	Respawn RespawnPolicy
+
+	// RestartPolicy limits how often envoy is restarted, a refused restart
+	// returns ErrRestartThrottled. Unlimited by default.
+	RestartPolicy RestartPolicy
+
+	// HotRestartMismatch decides what a restart does when the new envoy has
+	// another hot restart version than the running epoch, HotRestartCold by
+	// default.
+	HotRestartMismatch HotRestartPolicy
+
+	// MetricsAddress is the host:port the supervisor metrics are served on
+	// at /metrics, in the prometheus text format. Disabled when empty.
+	MetricsAddress string
+
+	// ControlAddress is where the HTTP control API is served, host:port or
+	// unix:/path/to/socket. Disabled when empty. When ControlToken is set
+	// every request must carry it as a bearer token, a host:port other than
+	// a loopback one is refused without it.
+	ControlAddress string
+	ControlToken   string
+
+	// RunDir is where the supervisor writes its pid file, PIDFileName, and by
+	// default serves the control API on the unix socket ControlSocketName, so
+	// reenvoyctl can find it. Disabled when empty.
+	RunDir string
+
+	// WatchConfig restart envoy when the config files under ConfigPath
+	// change. The files are polled every WatchInterval and a change has to
+	// settle for WatchDebounce before restarting.
+	WatchConfig   bool
+	WatchInterval time.Duration
+	WatchDebounce time.Duration
+
+	// StateFile is where the restart epoch, the children pids and the config
+	// hash are recorded. When set, Start adopts the children of a previous
+	// supervisor that are still alive and continue at the next epoch.
+	StateFile string
 }
 
-//SpawnProcess spawn new process and return instance of process
-func SpawnProcess(opt SpawnOptions, restartEpoch int) (*Process, error) {
+//SpawnProcess spawn new process and return instance of process.
+// With an AdminAddress it only returns once envoy is live, the process is
+// killed when it does not get there within ReadyTimeout.
+func SpawnProcess(opt SpawnOptions) (*Process, error) {
 	opt = defaultOptions(opt)
+	p, err := startProcess(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.AdminAddress != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), opt.ReadyTimeout)
+		defer cancel()
+
+		if err := waitReady(ctx, opt.AdminAddress, opt.RestartEpoch, p.Exited()); err != nil {
+			p.Kill()
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// startProcess start the process without waiting for it to be ready.
+func startProcess(opt SpawnOptions) (*Process, error) {
+	if err := opt.Validate(); err != nil {
+		return nil, err
+	}
+
 	p := &Process{
-		Env:                 opt.Env,
+		Command:             opt.Command,
+		Args:                opt.Args,
+		ReloadSignal:        opt.ReloadSignal,
+		KillSignal:          opt.KillSignal,
+		Env:                 epochEnv(opt.Env, opt.RestartEpoch),
 		Timeout:             opt.Timeout,
 		KillTimeout:         opt.KillTimeout,
 		Stdout:              opt.Stdout,
 		StdErr:              opt.StdErr,
 		DockerContainer:     opt.DockerContainer,
+		Docker:              opt.Docker,
 		ConfigPath:          opt.ConfigPath,
 		DrainTimes:          opt.DrainTimes,
 		ParentShutdownTimes: opt.ParentShutdownTimes,
-		restartEpoch:        restartEpoch,
+		restartEpoch:        opt.RestartEpoch,
+		BinaryPath:          opt.BinaryPath,
+		EnvoyOptions:        opt.Envoy,
+		ExtraArgs:           opt.ExtraArgs,
 	}
 
 	if err := p.Start(); err != nil {
@@ -68,6 +207,29 @@ func SpawnProcess(opt SpawnOptions, restartEpoch int) (*Process, error) {
 	return p, nil
 }
 
+// Validate check the envoy options, the docker options and extra args.
+func (opt SpawnOptions) Validate() error {
+	if err := opt.Envoy.Validate(); err != nil {
+		return err
+	}
+	if err := opt.Docker.Validate(); err != nil {
+		return err
+	}
+	return validateExtraArgs(opt.ExtraArgs)
+}
+
+// epochEnv return env with RESTART_EPOCH set, the way hot-restarter.py export
+// it to the processes that care. A nil env starts from our own environment.
+func epochEnv(env []string, restartEpoch int) []string {
+	if env == nil {
+		env = os.Environ()
+	}
+
+	epochEnv := make([]string, 0, len(env)+1)
+	epochEnv = append(epochEnv, env...)
+	return append(epochEnv, fmt.Sprintf("RESTART_EPOCH=%d", restartEpoch))
+}
+
 func defaultOptions(opt SpawnOptions) SpawnOptions {
 	if opt.KillTimeout.Nanoseconds() < 1 {
 		opt.KillTimeout = 5 * time.Second
@@ -80,5 +242,25 @@ func defaultOptions(opt SpawnOptions) SpawnOptions {
 	if opt.ParentShutdownTimes.Nanoseconds() < 1 {
 		opt.ParentShutdownTimes = 70 * time.Second
 	}
+
+	if opt.HealthyTimeout.Nanoseconds() < 1 {
+		opt.HealthyTimeout = 5 * time.Second
+	}
+
+	if opt.ReadyTimeout.Nanoseconds() < 1 {
+		opt.ReadyTimeout = 30 * time.Second
+	}
+
+	if opt.WatchInterval.Nanoseconds() < 1 {
+		opt.WatchInterval = time.Second
+	}
+
+	if opt.WatchDebounce.Nanoseconds() < 1 {
+		opt.WatchDebounce = 2 * time.Second
+	}
+
+	if opt.RunDir != "" && opt.ControlAddress == "" {
+		opt.ControlAddress = ControlSocket(opt.RunDir)
+	}
 	return opt
 }
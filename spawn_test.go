@@ -0,0 +1,16 @@
+package reenvoy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEpochEnv(t *testing.T) {
+	t.Parallel()
+
+	env := []string{"a=b"}
+	assert.Equal(t, []string{"a=b", "RESTART_EPOCH=2"}, epochEnv(env, 2))
+	assert.Equal(t, []string{"a=b"}, env)
+	assert.Contains(t, epochEnv(nil, 0), "RESTART_EPOCH=0")
+}
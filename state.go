@@ -0,0 +1,198 @@
+package reenvoy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// supervisorState is what the state file records, enough for a restarted
+// supervisor to find the children of the previous one.
+type supervisorState struct {
+	// Epoch is the restart epoch of the newest child.
+	Epoch      int          `json:"epoch"`
+	ConfigHash string       `json:"config_hash,omitempty"`
+	Children   []stateChild `json:"children"`
+}
+
+type stateChild struct {
+	PID       PID       `json:"pid"`
+	Epoch     int       `json:"epoch"`
+	Command   string    `json:"command,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+
+	HotRestartVersion string `json:"hot_restart_version,omitempty"`
+}
+
+// readState read the state file at path, a missing file is an empty state.
+func readState(path string) (*supervisorState, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := &supervisorState{}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, fmt.Errorf("state file %s: %s", path, err)
+	}
+	return state, nil
+}
+
+// writeState replace the state file at path atomically, a reader always sees
+// either the previous or the new state.
+func writeState(path string, state *supervisorState) error {
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+// writeFileAtomic write b to a temporary file next to path and rename it over
+// path. The mode of an existing file is kept, 0644 otherwise.
+func writeFileAtomic(path string, b []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// configHash return the hash of the config files under configPath, empty
+// when they can not be read.
+func configHash(configPath string) string {
+	if configPath == "" {
+		return ""
+	}
+
+	snapshot, err := snapshotConfig(configPath)
+	if err != nil {
+		return ""
+	}
+	return snapshot.hash()
+}
+
+// saveState record the live children to Options.StateFile, if any.
+func (r *Reenvoy) saveState() {
+	opt := r.options()
+	if opt.StateFile == "" {
+		return
+	}
+
+	state := &supervisorState{
+		Epoch:      r.Epoch(),
+		ConfigHash: configHash(opt.ConfigPath),
+		Children:   []stateChild{},
+	}
+	for _, epoch := range r.epochs.list() {
+		state.Children = append(state.Children, stateChild{
+			PID:       epoch.PID,
+			Epoch:     epoch.Epoch,
+			Command:   childCommand(epoch.Child),
+			StartedAt: epoch.StartedAt,
+
+			HotRestartVersion: epoch.HotRestartVersion,
+		})
+	}
+
+	if err := writeState(opt.StateFile, state); err != nil {
+		log.Println("[ERR] write state file:", err)
+	}
+}
+
+// restoreState adopt the children recorded in Options.StateFile that are
+// still alive and continue at the epoch after the newest of them. Children
+// that are gone are dropped. When none survived we start back at epoch 0, a
+// new epoch would have no parent to take over from.
+func (r *Reenvoy) restoreState() error {
+	opt := r.options()
+	if opt.StateFile == "" {
+		return nil
+	}
+
+	state, err := readState(opt.StateFile)
+	if err != nil || state == nil {
+		return err
+	}
+
+	if hash := configHash(opt.ConfigPath); hash != state.ConfigHash {
+		log.Println("[INFO] config changed since the state file was written")
+	}
+
+	for _, child := range state.Children {
+		if !processAlive(child.PID, child.Command) {
+			log.Printf("[INFO] PID=%v epoch %v from state file is gone\n", child.PID, child.Epoch)
+			continue
+		}
+
+		log.Printf("[INFO] adopting PID=%v epoch %v\n", child.PID, child.Epoch)
+		p := adoptProcess(child)
+		r.epochs.add(&Epoch{
+			Child:     p,
+			PID:       child.PID,
+			Epoch:     child.Epoch,
+			StartedAt: child.StartedAt,
+			State:     StateRunning,
+
+			HotRestartVersion: child.HotRestartVersion,
+		})
+		go r.watch(child.PID, p)
+
+		if child.Epoch >= r.restartEpoch {
+			r.restartEpoch = child.Epoch + 1
+		}
+	}
+
+	return nil
+}
+
+// processAlive check pid is still running command. The command is only
+// compared when /proc is available, a reused pid would be adopted otherwise.
+func processAlive(pid PID, command string) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	if err := syscall.Kill(int(pid), 0); err != nil && err != syscall.EPERM {
+		return false
+	}
+
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil || command == "" {
+		return true
+	}
+
+	argv0 := string(bytes.SplitN(cmdline, []byte{0}, 2)[0])
+	return filepath.Base(argv0) == filepath.Base(command)
+}
@@ -0,0 +1,68 @@
+package reenvoy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteState(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "reenvoy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+	state, err := readState(path)
+	require.Nil(t, err)
+	require.Nil(t, state)
+
+	expected := &supervisorState{
+		Epoch:      3,
+		ConfigHash: "abc",
+		Children:   []stateChild{{PID: 10, Epoch: 2}, {PID: 11, Epoch: 3}},
+	}
+	require.Nil(t, writeState(path, expected))
+
+	state, err = readState(path)
+	require.Nil(t, err)
+	assert.Equal(t, expected.Epoch, state.Epoch)
+	assert.Equal(t, expected.ConfigHash, state.ConfigHash)
+	assert.Len(t, state.Children, 2)
+
+	// the temporary file must be renamed over the state file
+	files, err := ioutil.ReadDir(dir)
+	require.Nil(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestRestoreState(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "reenvoy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+	require.Nil(t, writeState(path, &supervisorState{
+		Epoch: 4,
+		Children: []stateChild{
+			// the test binary stands for a child still alive
+			{PID: PID(os.Getpid()), Epoch: 4, Command: os.Args[0]},
+			{PID: PID(os.Getpid()), Epoch: 3, Command: "not-the-same-command"},
+		},
+	}))
+
+	r := newReenvoy(SpawnOptions{StateFile: path})
+	require.Nil(t, r.restoreState())
+
+	children := r.Children()
+	require.Len(t, children, 1)
+	assert.Equal(t, 4, children[0].Epoch)
+	assert.Equal(t, 5, r.restartEpoch)
+}
@@ -0,0 +1,88 @@
+package reenvoy
+
+import (
+	"syscall"
+	"time"
+)
+
+// Status describe a child, or the supervisor through its newest epoch, at a
+// point in time.
+type Status struct {
+	State     ChildState
+	PID       PID
+	Epoch     int
+	StartedAt time.Time
+
+	// ExitCode and Signal tell how the process went away, once it is
+	// StateExited or StateFailed.
+	ExitCode int
+	Signal   syscall.Signal
+
+	// Err is the last error, why the process could not be started or the
+	// last restart failed.
+	Err error
+}
+
+// exitState is StateExited for a clean exit, StateFailed otherwise.
+func exitState(code int, signal syscall.Signal) ChildState {
+	if code != ExitCodeOK || signal != 0 {
+		return StateFailed
+	}
+	return StateExited
+}
+
+// shellStatus is the status a child exited with the way a shell reports it,
+// 128+signal when it was killed by a signal.
+func shellStatus(code int, signal syscall.Signal) int {
+	if signal != 0 {
+		return 128 + int(signal)
+	}
+	return code
+}
+
+// Status describe the newest epoch, its state is the one Reenvoy knows it by
+// until it has exited. Once every child is gone for good it tells how the
+// supervisor exits.
+func (r *Reenvoy) Status() Status {
+	r.exitLock.Lock()
+	lastErr, lastExit, exitCode := r.lastErr, r.lastExit, r.exitCode
+	r.exitLock.Unlock()
+
+	select {
+	case <-r.done:
+		return Status{
+			State:    exitState(exitCode, 0),
+			PID:      lastExit.pid,
+			Epoch:    lastExit.epoch,
+			ExitCode: exitCode,
+			Signal:   lastExit.signal,
+			Err:      lastErr,
+		}
+	default:
+	}
+
+	current, ok := r.epochs.currentCopy()
+	if !ok {
+		// not started yet or waiting to respawn
+		return Status{State: StateStarting, Epoch: -1, Err: lastErr}
+	}
+
+	status := current.Child.Status()
+	if status.State != StateExited && status.State != StateFailed {
+		status.State = current.State
+	}
+	status.PID = current.PID
+	status.Epoch = current.Epoch
+	status.StartedAt = current.StartedAt
+	if lastErr != nil {
+		status.Err = lastErr
+	}
+	return status
+}
+
+// setLastErr keep err for Status.
+func (r *Reenvoy) setLastErr(err error) {
+	r.exitLock.Lock()
+	r.lastErr = err
+	r.exitLock.Unlock()
+}
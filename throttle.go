@@ -0,0 +1,112 @@
+package reenvoy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrRestartThrottled is returned by a restart refused by the RestartPolicy.
+var ErrRestartThrottled = errors.New("restart throttled")
+
+// RestartPolicy limits how often envoy is restarted, so a caller or the
+// config watcher restarting in a tight loop can't take it down. Zero values
+// disable the matching limit.
+type RestartPolicy struct {
+	// MinInterval is the minimum time between two restarts.
+	MinInterval time.Duration
+
+	// Backoff is how long to wait after a failed restart, doubled after
+	// every consecutive failure up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// MaxFailures failed restarts within FailureWindow put envoy in the
+	// crash-looping state, restarts are refused until the oldest failure
+	// left the window.
+	MaxFailures   int
+	FailureWindow time.Duration
+}
+
+// restartThrottle keeps the history RestartPolicy is applied to.
+type restartThrottle struct {
+	sync.Mutex
+	policy RestartPolicy
+
+	last        time.Time
+	lastFailure time.Time
+	failures    []time.Time
+	consecutive int
+}
+
+// allow tells why a restart at now is refused, nil when it may go on.
+func (t *restartThrottle) allow(now time.Time) error {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.crashLooping(now) {
+		return fmt.Errorf("crash-looping, %v restarts failed within %v", len(t.failures), t.policy.FailureWindow)
+	}
+
+	if wait := t.policy.MinInterval - now.Sub(t.last); !t.last.IsZero() && wait > 0 {
+		return fmt.Errorf("last restart was less than %v ago", t.policy.MinInterval)
+	}
+
+	if t.consecutive > 0 && t.policy.Backoff > 0 {
+		backoff := t.policy.Backoff << uint(t.consecutive-1)
+		if t.policy.MaxBackoff > 0 && (backoff > t.policy.MaxBackoff || backoff <= 0) {
+			backoff = t.policy.MaxBackoff
+		}
+		if now.Sub(t.lastFailure) < backoff {
+			return fmt.Errorf("backing off for %v after %v failed restarts", backoff, t.consecutive)
+		}
+	}
+	return nil
+}
+
+// record a restart that happened at now.
+func (t *restartThrottle) record(now time.Time, failed bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.last = now
+	if !failed {
+		t.consecutive = 0
+		return
+	}
+
+	t.consecutive++
+	t.lastFailure = now
+	t.failures = append(t.failures, now)
+	if t.crashLooping(now) {
+		log.Printf("[ERR] crash-looping, %v restarts failed within %v\n", len(t.failures), t.policy.FailureWindow)
+	}
+}
+
+// crashLooping tells whether MaxFailures restarts failed within the window,
+// failures older than the window are forgotten. The caller holds the lock.
+func (t *restartThrottle) crashLooping(now time.Time) bool {
+	if t.policy.MaxFailures <= 0 {
+		t.failures = nil
+		return false
+	}
+
+	recent := t.failures[:0]
+	for _, failure := range t.failures {
+		if t.policy.FailureWindow <= 0 || now.Sub(failure) < t.policy.FailureWindow {
+			recent = append(recent, failure)
+		}
+	}
+	t.failures = recent
+	return len(t.failures) >= t.policy.MaxFailures
+}
+
+// CrashLooping tells whether restarts are refused because too many of them
+// failed within RestartPolicy.FailureWindow.
+func (r *Reenvoy) CrashLooping() bool {
+	r.throttle.Lock()
+	defer r.throttle.Unlock()
+	return r.throttle.crashLooping(time.Now())
+}
@@ -0,0 +1,58 @@
+package reenvoy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestartThrottle(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	at := func(d time.Duration) time.Time { return now.Add(d) }
+
+	t.Run("min interval", func(t *testing.T) {
+		throttle := restartThrottle{policy: RestartPolicy{MinInterval: time.Second}}
+		assert.Nil(t, throttle.allow(at(0)))
+		throttle.record(at(0), false)
+
+		assert.NotNil(t, throttle.allow(at(500*time.Millisecond)))
+		assert.Nil(t, throttle.allow(at(time.Second)))
+	})
+
+	t.Run("backoff", func(t *testing.T) {
+		throttle := restartThrottle{policy: RestartPolicy{Backoff: time.Second, MaxBackoff: 3 * time.Second}}
+		throttle.record(at(0), true)
+		assert.NotNil(t, throttle.allow(at(500*time.Millisecond)))
+		assert.Nil(t, throttle.allow(at(time.Second)))
+
+		// doubled after the second failure, capped after the third
+		throttle.record(at(time.Second), true)
+		assert.NotNil(t, throttle.allow(at(2*time.Second)))
+		assert.Nil(t, throttle.allow(at(3*time.Second)))
+
+		throttle.record(at(3*time.Second), true)
+		assert.NotNil(t, throttle.allow(at(5*time.Second)))
+		assert.Nil(t, throttle.allow(at(6*time.Second)))
+
+		// a successful restart resets the backoff
+		throttle.record(at(6*time.Second), false)
+		assert.Nil(t, throttle.allow(at(6*time.Second)))
+	})
+
+	t.Run("crash looping", func(t *testing.T) {
+		throttle := restartThrottle{policy: RestartPolicy{MaxFailures: 2, FailureWindow: 10 * time.Second}}
+		throttle.record(at(0), true)
+		assert.False(t, throttle.crashLooping(at(time.Second)))
+
+		throttle.record(at(time.Second), true)
+		assert.True(t, throttle.crashLooping(at(time.Second)))
+		assert.NotNil(t, throttle.allow(at(5*time.Second)))
+
+		// the first failure left the window
+		assert.Nil(t, throttle.allow(at(10*time.Second)))
+		assert.False(t, throttle.crashLooping(at(10*time.Second)))
+	})
+}
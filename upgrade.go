@@ -0,0 +1,73 @@
+package reenvoy
+
+import (
+	"context"
+)
+
+// Upgrade hot restart to the envoy binary at binaryPath. When its hot restart
+// version differs from the running epoch HotRestartMismatch decides, by
+// default every epoch is stopped and envoy is started again at epoch 0, the
+// returned error is then a *HotRestartVersionError.
+func (r *Reenvoy) Upgrade(binaryPath string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	opt := r.options()
+	opt.BinaryPath = binaryPath
+	return r.restartWith(opt).Err
+}
+
+// UpgradeImage is Upgrade for docker, image is the full image reference
+// including its tag.
+func (r *Reenvoy) UpgradeImage(image string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	opt := r.options()
+	opt.Docker.Image = image
+	opt.Docker.Tag = ""
+	return r.restartWith(opt).Err
+}
+
+// coldRestart stop every epoch and start envoy again at epoch 0 with opt,
+// version is its hot restart version. The epochs are forgotten before being
+// stopped so their exit is not taken for a failure. The caller holds mu.
+func (r *Reenvoy) coldRestart(opt SpawnOptions, version string) (*Epoch, error) {
+	epochs := r.epochs.list()
+	for _, epoch := range epochs {
+		r.epochs.remove(epoch.PID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.options().KillTimeout)
+	defer cancel()
+	stopEpochs(ctx, epochs)
+
+	// a new epoch 0 owns the shared memory again, start from an empty one
+	if opt.usesIPCHolder() {
+		opt.Docker.removeIPCHolder()
+	}
+	r.restartEpoch = 0
+	epoch, err := r.spawn(opt, StateStarting, version)
+	if err == nil {
+		err = r.waitHealthy(epoch)
+		if err != nil {
+			r.epochs.remove(epoch.PID)
+			epoch.Child.Kill()
+		}
+	}
+
+	if err != nil {
+		r.exitLock.Lock()
+		if r.exitCode == ExitCodeOK {
+			r.exitCode = 1
+		}
+		r.exitLock.Unlock()
+		r.finishIfEmpty()
+		return nil, err
+	}
+
+	r.setOptions(opt)
+	r.markReady(epoch, nil)
+	r.takeConfigSnapshot()
+	return epoch, nil
+}
@@ -0,0 +1,124 @@
+package reenvoy_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evo3cx/reenvoy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// upgradedEnvoy copy the fakeEnvoy shim with another hot restart version.
+func upgradedEnvoy(t *testing.T, opts reenvoy.SpawnOptions, version string) string {
+	b, err := ioutil.ReadFile(opts.BinaryPath)
+	require.Nil(t, err)
+
+	path := filepath.Join(filepath.Dir(opts.BinaryPath), "envoy-"+version)
+	shim := strings.Replace(string(b), "echo 11.104", "echo "+version, 1)
+	require.Nil(t, ioutil.WriteFile(path, []byte(shim), 0755))
+	return path
+}
+
+func TestReenvoy_Upgrade(t *testing.T) {
+	opts, restore := fakeEnvoy(t)
+	defer restore()
+
+	re, err := reenvoy.Start(opts)
+	require.Nil(t, err, "start reenvoy")
+
+	err = re.Upgrade(upgradedEnvoy(t, opts, "11.104"))
+	require.Nil(t, err)
+
+	// same hot restart version, the new binary takes over at the next epoch
+	children := re.Children()
+	require.Len(t, children, 2)
+	assert.Equal(t, 1, children[1].Epoch)
+	assert.Equal(t, "11.104", children[1].HotRestartVersion)
+
+	stopReenvoy(t, re)
+}
+
+func TestReenvoy_UpgradeIncompatible(t *testing.T) {
+	opts, restore := fakeEnvoy(t)
+	defer restore()
+	// a single failed restart would refuse the next one
+	opts.RestartPolicy = reenvoy.RestartPolicy{MaxFailures: 1, FailureWindow: time.Minute}
+
+	re, err := reenvoy.Start(opts)
+	require.Nil(t, err, "start reenvoy")
+
+	events, unsubscribe := re.Events()
+	defer unsubscribe()
+
+	err = re.Upgrade(upgradedEnvoy(t, opts, "12.200"))
+	require.NotNil(t, err)
+
+	verr, ok := err.(*reenvoy.HotRestartVersionError)
+	require.True(t, ok, "expected a hot restart version error, got %s", err)
+	assert.Equal(t, "11.104", verr.Current)
+	assert.Equal(t, "12.200", verr.New)
+	assert.Equal(t, reenvoy.HotRestartCold, verr.Policy)
+	assert.Nil(t, verr.Err)
+
+	// envoy was cold restarted, the old epoch is gone
+	children := re.Children()
+	require.Len(t, children, 1)
+	assert.Equal(t, 0, children[0].Epoch)
+	assert.Equal(t, reenvoy.StateRunning, children[0].State)
+	assert.Nil(t, re.Status().Err, "a cold restart is not a failed restart")
+
+	// the hot restart version matches again from now on
+	require.Nil(t, re.Restart())
+	assert.Equal(t, 1, re.Epoch())
+
+	stopReenvoy(t, re)
+	for event := range events {
+		assert.NotEqual(t, reenvoy.RestartFailed, event.Type)
+	}
+}
+
+func TestReenvoy_HotRestartMismatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  reenvoy.HotRestartPolicy
+		wantErr bool
+		epochs  []int
+		version string
+	}{
+		{name: "refuse", policy: reenvoy.HotRestartRefuse, wantErr: true, epochs: []int{0}, version: "11.104"},
+		{name: "warn", policy: reenvoy.HotRestartWarn, epochs: []int{0, 1}, version: "12.200"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, restore := fakeEnvoy(t)
+			defer restore()
+			opts.HotRestartMismatch = tt.policy
+
+			re, err := reenvoy.Start(opts)
+			require.Nil(t, err, "start reenvoy")
+
+			err = re.Upgrade(upgradedEnvoy(t, opts, "12.200"))
+			if tt.wantErr {
+				require.IsType(t, &reenvoy.HotRestartVersionError{}, err)
+				assert.Contains(t, err.Error(), "not restarting")
+			} else {
+				assert.Nil(t, err)
+			}
+
+			children := re.Children()
+			epochs := []int{}
+			for _, child := range children {
+				epochs = append(epochs, child.Epoch)
+			}
+			assert.Equal(t, tt.epochs, epochs)
+			assert.Equal(t, tt.version, children[len(children)-1].HotRestartVersion)
+
+			stopReenvoy(t, re)
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package reenvoy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// validateTimeout is the maximum amount of time envoy is given to validate a
+// config.
+const validateTimeout = 30 * time.Second
+
+// ConfigValidationError is returned when envoy refuses the config, Messages
+// holds what the validator printed.
+type ConfigValidationError struct {
+	ConfigPath string
+	Messages   []string
+	Err        error
+}
+
+func (e *ConfigValidationError) Error() string {
+	if len(e.Messages) == 0 {
+		return fmt.Sprintf("invalid envoy config %s: %s", e.ConfigPath, e.Err)
+	}
+	return fmt.Sprintf("invalid envoy config %s: %s", e.ConfigPath, strings.Join(e.Messages, "; "))
+}
+
+// validateCommand return the command line checking the config with envoy
+// --mode validate, natively or in docker. The envoy options and extra args are
+// the ones envoy is served with, they may change how the config is read.
+func validateCommand(opt SpawnOptions) (string, []string) {
+	if opt.DockerContainer {
+		args := []string{
+			"run",
+			"--rm",
+			"-v",
+			fmt.Sprintf("%s:%s", opt.ConfigPath, opt.Docker.configDir()),
+		}
+		for _, volume := range opt.Docker.Volumes {
+			args = append(args, "-v", volume)
+		}
+		for _, env := range opt.Docker.Env {
+			args = append(args, "-e", env)
+		}
+		args = append(args, opt.Docker.image(), defaultEnvoyBinary)
+		args = append(args, "--mode", "validate", "-c", opt.Docker.configDir()+"/envoy.yaml")
+		args = append(args, opt.Envoy.args()...)
+		return "docker", append(args, opt.ExtraArgs...)
+	}
+
+	command := opt.BinaryPath
+	if command == "" {
+		command = defaultEnvoyBinary
+	}
+	args := []string{"--mode", "validate", "-c", fmt.Sprintf("%s/envoy.yaml", opt.ConfigPath)}
+	args = append(args, opt.Envoy.args()...)
+	return command, append(args, opt.ExtraArgs...)
+}
+
+// ValidateConfig run envoy --mode validate against the config under
+// ConfigPath. A config refused by envoy is reported as a
+// *ConfigValidationError. Nothing is validated when supervising a Command.
+func (r *Reenvoy) ValidateConfig() error {
+	return r.validate(r.options())
+}
+
+// validate is validateConfig counting the failures.
+func (r *Reenvoy) validate(opt SpawnOptions) error {
+	err := validateConfig(opt)
+	if err != nil {
+		r.metrics.validationFailed()
+	}
+	return err
+}
+
+// validateConfig validate the config with the envoy binary or image of opt.
+func validateConfig(opt SpawnOptions) error {
+	if opt.Command != "" || opt.SkipConfigValidation {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), validateTimeout)
+	defer cancel()
+
+	command, args := validateCommand(opt)
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Env = opt.Env
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := err.(*exec.ExitError); !ok {
+		return fmt.Errorf("validate envoy config: %s", err)
+	}
+
+	verr := &ConfigValidationError{ConfigPath: opt.ConfigPath, Err: err}
+	for _, line := range strings.Split(out.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			verr.Messages = append(verr.Messages, line)
+		}
+	}
+	return verr
+}
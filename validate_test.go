@@ -0,0 +1,39 @@
+package reenvoy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateCommand(t *testing.T) {
+	t.Parallel()
+
+	opt := SpawnOptions{
+		BinaryPath: "/usr/local/bin/envoy",
+		ConfigPath: "/etc/envoy",
+		Envoy:      EnvoyOptions{ServiceCluster: "front"},
+		ExtraArgs:  []string{"--bootstrap-version", "3"},
+	}
+
+	command, args := validateCommand(opt)
+	assert.Equal(t, "/usr/local/bin/envoy", command)
+	assert.Equal(t, []string{
+		"--mode", "validate",
+		"-c", "/etc/envoy/envoy.yaml",
+		"--service-cluster", "front",
+		"--bootstrap-version", "3",
+	}, args)
+
+	// the config is validated the way it is served
+	opt.DockerContainer = true
+	command, args = validateCommand(opt)
+	assert.Equal(t, "docker", command)
+	assert.Equal(t, []string{
+		"envoy",
+		"--mode", "validate",
+		"-c", "/testdata/envoy.yaml",
+		"--service-cluster", "front",
+		"--bootstrap-version", "3",
+	}, args[len(args)-9:])
+}
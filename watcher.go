@@ -0,0 +1,67 @@
+package reenvoy
+
+import (
+	"log"
+	"time"
+)
+
+// watchConfig poll the config files under ConfigPath and restart envoy
+// once a change settled for WatchDebounce. Hashing the content rather than
+// watching file events covers editors writing in place, atomic rename-replace
+// and the ..data symlink swap of a kubernetes ConfigMap alike, and a change
+// bringing back the running content does not restart anything.
+func (r *Reenvoy) watchConfig() {
+	// restarts only swap the binary, image and drain times
+	opt := r.options()
+	ticker := time.NewTicker(opt.WatchInterval)
+	defer ticker.Stop()
+
+	// pending is the content seen last and changedAt when it showed up,
+	// attempted is the content a restart was last requested for.
+	pending := r.runningConfigHash()
+	changedAt := time.Now()
+	attempted := pending
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+
+		hash := configHash(opt.ConfigPath)
+		if hash == "" {
+			// in the middle of a swap
+			continue
+		}
+
+		if hash != pending {
+			pending = hash
+			changedAt = time.Now()
+			continue
+		}
+
+		if time.Since(changedAt) < opt.WatchDebounce {
+			continue
+		}
+
+		if hash == r.runningConfigHash() || hash == attempted || r.Epoch() < 0 {
+			continue
+		}
+
+		if err := r.ValidateConfig(); err != nil {
+			log.Println("[ERR] config changed but is not valid, not restarting:", err)
+			attempted = hash
+			continue
+		}
+
+		// a throttled restart is tried again on the next tick
+		log.Println("[INFO] config changed, restarting")
+		res := r.restart()
+		res.Requests = 1
+		r.publishResult(res)
+		if res.Err != ErrRestartThrottled {
+			attempted = hash
+		}
+	}
+}
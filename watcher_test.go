@@ -0,0 +1,107 @@
+package reenvoy_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/evo3cx/reenvoy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func watchOptions(t *testing.T) (opts reenvoy.SpawnOptions, restore func()) {
+	opts, restore = fakeEnvoy(t)
+	opts.WatchConfig = true
+	opts.WatchInterval = 50 * time.Millisecond
+	opts.WatchDebounce = 200 * time.Millisecond
+	return opts, restore
+}
+
+// waitEpoch poll until the newest epoch is epoch and running or timeout.
+func waitEpoch(re reenvoy.ReEnvoy, epoch int, timeout time.Duration) bool {
+	running := func() bool {
+		children := re.Children()
+		if len(children) == 0 {
+			return false
+		}
+		newest := children[len(children)-1]
+		return newest.Epoch == epoch && newest.State == reenvoy.StateRunning
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if running() {
+			return true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return running()
+}
+
+func stopReenvoy(t *testing.T, re reenvoy.ReEnvoy) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	assert.True(t, re.StopAllChildren(ctx).Clean())
+	assert.Equal(t, 0, re.Wait())
+}
+
+func TestReenvoy_WatchConfig(t *testing.T) {
+	opts, restore := watchOptions(t)
+	defer restore()
+
+	re, err := reenvoy.Start(opts)
+	require.Nil(t, err, "start reenvoy")
+	time.Sleep(fileWaitSleepDelay)
+
+	// same content rewritten, nothing to do
+	config := filepath.Join(opts.ConfigPath, "envoy.yaml")
+	require.Nil(t, ioutil.WriteFile(config, []byte("good"), 0644))
+	assert.False(t, waitEpoch(re, 1, time.Second))
+
+	// a burst of writes is a single restart
+	for _, content := range []string{"good 1", "good 2", "good 3"} {
+		require.Nil(t, ioutil.WriteFile(config, []byte(content), 0644))
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.True(t, waitEpoch(re, 1, 3*time.Second))
+	assert.False(t, waitEpoch(re, 2, time.Second))
+
+	// an invalid config is not restarted to
+	require.Nil(t, ioutil.WriteFile(config, []byte("bad"), 0644))
+	assert.False(t, waitEpoch(re, 2, time.Second))
+
+	stopReenvoy(t, re)
+}
+
+func TestReenvoy_WatchConfigMap(t *testing.T) {
+	opts, restore := watchOptions(t)
+	defer restore()
+
+	// lay the config out like a kubernetes ConfigMap volume
+	dir, err := ioutil.TempDir("", "reenvoy-configmap")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	writeVersion := func(version, content string) {
+		require.Nil(t, os.Mkdir(filepath.Join(dir, version), 0755))
+		require.Nil(t, ioutil.WriteFile(filepath.Join(dir, version, "envoy.yaml"), []byte(content), 0644))
+		require.Nil(t, os.Symlink(version, filepath.Join(dir, "..data_tmp")))
+		require.Nil(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+	}
+	writeVersion("..v1", "good")
+	require.Nil(t, os.Symlink("..data/envoy.yaml", filepath.Join(dir, "envoy.yaml")))
+	opts.ConfigPath = dir
+
+	re, err := reenvoy.Start(opts)
+	require.Nil(t, err, "start reenvoy")
+	time.Sleep(fileWaitSleepDelay)
+
+	writeVersion("..v2", "good v2")
+	assert.True(t, waitEpoch(re, 1, 3*time.Second))
+
+	stopReenvoy(t, re)
+}